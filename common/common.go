@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -28,7 +29,18 @@ func ExecuteBash(execution Environment, request *plugin.ExecuteActionRequest, en
 	return ExecuteCommand(execution, request, environment, "/bin/bash", "-c", cmd)
 }
 
+// ExecuteCommand runs the command as the executor, the secrets of the request are masked in its output.
 func ExecuteCommand(execution Environment, request *plugin.ExecuteActionRequest, environment []string, name string, args ...string) ([]byte, error) {
+	output, err := ExecuteCommandUnredacted(execution, request, environment, name, args...)
+	if request != nil {
+		output = RedactSecrets(request, output)
+	}
+	return output, err
+}
+
+// ExecuteCommandUnredacted runs the command like ExecuteCommand but returns its raw output, for structured output
+// (e.g. runner json) which the caller redacts once decoded.
+func ExecuteCommandUnredacted(execution Environment, request *plugin.ExecuteActionRequest, environment []string, name string, args ...string) ([]byte, error) {
 
 	commandFinished := make(chan struct{})
 	command := exec.Command(
@@ -36,6 +48,12 @@ func ExecuteCommand(execution Environment, request *plugin.ExecuteActionRequest,
 		args...)
 
 	command.Dir = execution.GetHomeDirectory()
+	if request != nil {
+		// user facing commands only see the plugin variables which are allowed in config.yaml
+		environment = append(PluginEnvironment(request.Name), environment...)
+		// secrets declared by the step are injected into its own commands only
+		environment = append(environment, requestSecretEnvironment(request)...)
+	}
 	environment = append(environment, fmt.Sprintf("HOME=%s", execution.GetHomeDirectory()))
 	environment = append(environment, fmt.Sprintf("PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:%[1]s/.local/bin:%[1]s/bin", execution.GetHomeDirectory()))
 	command.Env = environment
@@ -111,6 +129,24 @@ func GetCommandFailureResponse(output []byte, err error, cli bool) ([]byte, erro
 	return nil, errors.New(fmt.Sprintf("output (%d bytes): %s; error: %s", outLength, strOut, err))
 }
 
+// ReadFileAs reads a file as the executor, so a symlink can't point it at a file which only the plugin may read.
+// Files larger than maxSize are rejected.
+func ReadFileAs(execution Environment, name string, maxSize int) ([]byte, error) {
+	output, err := ExecuteCommand(execution, nil, nil, "/usr/bin/head", "-c", fmt.Sprintf("%d", maxSize+1), "--", name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading %s: %s", name, strings.TrimSpace(string(output)))
+	}
+	if len(output) > maxSize {
+		return nil, errors.Errorf("%s is larger than %d bytes", name, maxSize)
+	}
+	return output, nil
+}
+
+// ShellQuote quotes a single argument so it can be safely embedded in a bash command.
+func ShellQuote(argument string) string {
+	return "'" + strings.ReplaceAll(argument, "'", `'\''`) + "'"
+}
+
 func WriteToTempFile(execution Environment, bytes []byte, prefix string) (string, error) {
 	file, err := ioutil.TempFile(execution.GetHomeDirectory(), prefix)
 	if err != nil {
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// CoreConfig holds the settings of the core plugin which live next to the sdk settings in config.yaml.
+type CoreConfig struct {
+	Environment EnvironmentConfig `yaml:"environment"`
+	Policies    Policies          `yaml:"policies"`
+}
+
+var (
+	coreConfigMutex sync.RWMutex
+	coreConfig      = &CoreConfig{}
+)
+
+func LoadCoreConfig(configPath string) error {
+	rawConfig, err := os.ReadFile(configPath)
+	if err != nil {
+		return errors.Wrap(err, "failed reading core config")
+	}
+
+	loadedConfig := &CoreConfig{}
+	if err = yaml.Unmarshal(rawConfig, loadedConfig); err != nil {
+		return errors.Wrap(err, "failed parsing core config")
+	}
+
+	SetCoreConfig(loadedConfig)
+	return nil
+}
+
+func SetCoreConfig(config *CoreConfig) {
+	coreConfigMutex.Lock()
+	defer coreConfigMutex.Unlock()
+
+	coreConfig = config
+}
+
+func GetCoreConfig() *CoreConfig {
+	coreConfigMutex.RLock()
+	defer coreConfigMutex.RUnlock()
+
+	return coreConfig
+}
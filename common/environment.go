@@ -0,0 +1,44 @@
+package common
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// EnvironmentConfig decides which variables of the plugin process are handed to user code.
+// Patterns are shell globs (e.g. LC_*), a denied pattern always wins over an allowed one.
+type EnvironmentConfig struct {
+	Allowed []string            `yaml:"allowed"`
+	Denied  []string            `yaml:"denied"`
+	Actions map[string][]string `yaml:"actions"`
+}
+
+// PluginEnvironment returns the variables of the plugin process which the given action may see.
+func PluginEnvironment(action string) []string {
+	return GetCoreConfig().Environment.Filter(action, os.Environ())
+}
+
+// Filter keeps the variables which are allowed globally or for the action and aren't denied.
+func (c EnvironmentConfig) Filter(action string, environment []string) []string {
+	allowed := append(append([]string{}, c.Allowed...), c.Actions[action]...)
+
+	var filtered []string
+	for _, variable := range environment {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if matchesAny(c.Denied, name) || !matchesAny(allowed, name) {
+			continue
+		}
+		filtered = append(filtered, variable)
+	}
+	return filtered
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentConfigFilter(t *testing.T) {
+	config := EnvironmentConfig{
+		Allowed: []string{"LANG", "LC_*", "AWS_*"},
+		Denied:  []string{"AWS_WEB_IDENTITY_TOKEN_FILE", "*_SECRET"},
+		Actions: map[string][]string{
+			"bash": {"HTTP_PROXY"},
+		},
+	}
+
+	environment := []string{
+		"LANG=C.UTF-8",
+		"LC_ALL=C",
+		"AWS_REGION=us-east-1",
+		"AWS_WEB_IDENTITY_TOKEN_FILE=/var/run/secrets/token",
+		"HTTP_PROXY=http://proxy:3128",
+		"DB_SECRET=hunter2",
+		"HOSTNAME=plugin",
+	}
+
+	tests := []struct {
+		name     string
+		action   string
+		expected []string
+	}{
+		{
+			name:     "global allowlist",
+			action:   "python",
+			expected: []string{"LANG=C.UTF-8", "LC_ALL=C", "AWS_REGION=us-east-1"},
+		},
+		{
+			name:     "action opt in",
+			action:   "bash",
+			expected: []string{"LANG=C.UTF-8", "LC_ALL=C", "AWS_REGION=us-east-1", "HTTP_PROXY=http://proxy:3128"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test Filter(): "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, config.Filter(tt.action, environment))
+		})
+	}
+}
+
+func TestEnvironmentConfigFilterDefaultsToNothing(t *testing.T) {
+	assert.Empty(t, EnvironmentConfig{}.Filter("bash", []string{"PATH=/bin", "HOME=/root"}))
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PolicyEffectDeny            = "deny"
+	PolicyEffectRequireApproval = "require_approval"
+
+	// ApproveParameterName is the parameter which approves running a step that a policy holds back.
+	ApproveParameterName = "Approve"
+
+	// UnknownDeletions is used when the number of resources a step deletes isn't known before running it.
+	UnknownDeletions = -1
+)
+
+// Policy is a guardrail which is checked before an action runs. It applies when all of its conditions match:
+// the action, the command (a regular expression), the parameters (shell globs of their values) and the
+// number of deleted resources exceeding MaxDeletions. Steps whose deletions are unknown exceed any MaxDeletions.
+type Policy struct {
+	Name         string            `yaml:"name"`
+	Actions      []string          `yaml:"actions"`
+	Command      string            `yaml:"command"`
+	Parameters   map[string]string `yaml:"parameters"`
+	MaxDeletions *int              `yaml:"max_deletions"`
+	Effect       string            `yaml:"effect"`
+	Message      string            `yaml:"message"`
+}
+
+type Policies []Policy
+
+// PolicyInput describes the step which is about to run.
+type PolicyInput struct {
+	Action     string
+	Command    string
+	Parameters map[string]string
+	Deletions  int
+}
+
+// EvaluatePolicies checks the step against the policies of the core config.
+func EvaluatePolicies(input PolicyInput) error {
+	return GetCoreConfig().Policies.Evaluate(input)
+}
+
+// Evaluate returns an error when a policy denies the step, or requires an approval which wasn't given.
+func (p Policies) Evaluate(input PolicyInput) error {
+	approved, _ := strconv.ParseBool(input.Parameters[ApproveParameterName])
+
+	for _, policy := range p {
+		matched, err := policy.matches(input)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		switch policy.Effect {
+		case PolicyEffectRequireApproval:
+			if !approved {
+				return errors.Errorf("policy %s requires approval, set the %s parameter to run this step: %s", policy.Name, ApproveParameterName, policy.Message)
+			}
+		default:
+			// unknown effects deny, a typo in the config shouldn't turn a guardrail off
+			return errors.Errorf("blocked by policy %s: %s", policy.Name, policy.Message)
+		}
+	}
+
+	return nil
+}
+
+func (p Policy) matches(input PolicyInput) (bool, error) {
+	if len(p.Actions) > 0 && !matchesAny(p.Actions, input.Action) {
+		return false, nil
+	}
+
+	if p.Command != "" {
+		expression, err := regexp.Compile(p.Command)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid command pattern in policy %s", p.Name)
+		}
+		if !expression.MatchString(input.Command) {
+			return false, nil
+		}
+	}
+
+	for name, pattern := range p.Parameters {
+		if matched, _ := path.Match(pattern, input.Parameters[name]); !matched {
+			return false, nil
+		}
+	}
+
+	// an unknown number of deletions could be any number, so it matches
+	if p.MaxDeletions != nil && input.Deletions != UnknownDeletions && input.Deletions <= *p.MaxDeletions {
+		return false, nil
+	}
+
+	return true, nil
+}
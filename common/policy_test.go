@@ -0,0 +1,116 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoliciesEvaluate(t *testing.T) {
+	maxDeletions := 2
+	policies := Policies{
+		{
+			Name:       "no-production-destroy",
+			Actions:    []string{"terraform"},
+			Command:    `(^|\s)(destroy|-destroy)(\s|$)`,
+			Parameters: map[string]string{"Workspace": "prod*"},
+			Effect:     PolicyEffectDeny,
+			Message:    "destroying production is not allowed",
+		},
+		{
+			Name:    "no-namespace-deletion",
+			Actions: []string{"kubectl*"},
+			Command: `kubectl\s+delete\s+(ns|namespaces?)\b`,
+			Effect:  PolicyEffectDeny,
+		},
+		{
+			Name:         "limit-deletions",
+			Actions:      []string{"terraform"},
+			MaxDeletions: &maxDeletions,
+			Effect:       PolicyEffectRequireApproval,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		input   PolicyInput
+		wantErr string
+	}{
+		{
+			name:    "destroy in production",
+			input:   PolicyInput{Action: "terraform", Command: "terraform apply -destroy -auto-approve", Parameters: map[string]string{"Workspace": "production"}, Deletions: UnknownDeletions},
+			wantErr: "blocked by policy no-production-destroy: destroying production is not allowed",
+		},
+		{
+			name:    "destroy in staging",
+			input:   PolicyInput{Action: "terraform", Command: "terraform apply -destroy -auto-approve", Parameters: map[string]string{"Workspace": "staging"}, Deletions: UnknownDeletions},
+			wantErr: "policy limit-deletions requires approval",
+		},
+		{
+			name:  "destroy in staging approved",
+			input: PolicyInput{Action: "terraform", Command: "terraform apply -destroy -auto-approve", Parameters: map[string]string{"Workspace": "staging", ApproveParameterName: "true"}, Deletions: UnknownDeletions},
+		},
+		{
+			name:    "namespace deletion",
+			input:   PolicyInput{Action: "kubectl", Command: "kubectl delete namespace web", Deletions: UnknownDeletions},
+			wantErr: "blocked by policy no-namespace-deletion",
+		},
+		{
+			name:  "pod deletion",
+			input: PolicyInput{Action: "kubectl", Command: "kubectl delete pod web-0", Deletions: UnknownDeletions},
+		},
+		{
+			name:    "too many deletions",
+			input:   PolicyInput{Action: "terraform", Command: "terraform apply", Deletions: 3},
+			wantErr: "policy limit-deletions requires approval, set the Approve parameter to run this step",
+		},
+		{
+			name:  "too many deletions approved",
+			input: PolicyInput{Action: "terraform", Command: "terraform apply", Parameters: map[string]string{ApproveParameterName: "true"}, Deletions: 3},
+		},
+		{
+			name:  "deletions within the limit",
+			input: PolicyInput{Action: "terraform", Command: "terraform apply", Deletions: 2},
+		},
+		{
+			name:    "unknown deletions",
+			input:   PolicyInput{Action: "terraform", Command: "terraform apply", Deletions: UnknownDeletions},
+			wantErr: "policy limit-deletions requires approval",
+		},
+		{
+			name:  "no deletions",
+			input: PolicyInput{Action: "terraform", Command: "terraform plan", Deletions: 0},
+		},
+		{
+			name:  "other action",
+			input: PolicyInput{Action: "aws", Command: "kubectl delete ns web", Deletions: UnknownDeletions},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policies.Evaluate(tt.input)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPoliciesEvaluateInvalid(t *testing.T) {
+	policies := Policies{{Name: "broken", Command: "("}}
+
+	err := policies.Evaluate(PolicyInput{Command: "ls", Deletions: UnknownDeletions})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid command pattern in policy broken")
+	}
+
+	err = Policies{{Name: "typo", Effect: "dney"}}.Evaluate(PolicyInput{Command: "ls", Deletions: UnknownDeletions})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "blocked by policy typo")
+	}
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/blinkops/blink-sdk/plugin"
+)
+
+// values shorter than this aren't redacted, replacing every "1" or "yes" would only garble the output
+const minRedactedSecretLength = 4
+
+const redactedSecret = "***"
+
+var (
+	requestSecretsMutex sync.RWMutex
+	requestSecrets      = map[*plugin.ExecuteActionRequest]map[string]string{}
+)
+
+// RegisterRequestSecrets makes the secrets (variable name to value) available to the commands of a request only.
+func RegisterRequestSecrets(request *plugin.ExecuteActionRequest, secrets map[string]string) {
+	requestSecretsMutex.Lock()
+	defer requestSecretsMutex.Unlock()
+
+	requestSecrets[request] = secrets
+}
+
+func UnregisterRequestSecrets(request *plugin.ExecuteActionRequest) {
+	requestSecretsMutex.Lock()
+	defer requestSecretsMutex.Unlock()
+
+	delete(requestSecrets, request)
+}
+
+func getRequestSecrets(request *plugin.ExecuteActionRequest) map[string]string {
+	requestSecretsMutex.RLock()
+	defer requestSecretsMutex.RUnlock()
+
+	return requestSecrets[request]
+}
+
+// requestSecretEnvironment returns the secrets of the request as environment variables.
+func requestSecretEnvironment(request *plugin.ExecuteActionRequest) []string {
+	var environment []string
+	for name, value := range getRequestSecrets(request) {
+		environment = append(environment, fmt.Sprintf("%s=%s", name, value))
+	}
+	return environment
+}
+
+// RedactSecrets masks the secret values of the request in the output of a command.
+func RedactSecrets(request *plugin.ExecuteActionRequest, output []byte) []byte {
+	values := redactedValues(request)
+	if len(values) == 0 || len(output) == 0 {
+		return output
+	}
+
+	for _, value := range values {
+		output = bytes.ReplaceAll(output, []byte(value), []byte(redactedSecret))
+	}
+	return output
+}
+
+// RedactSecretsInValue masks the secret values of the request in the strings of a decoded json value,
+// e.g. the context written back by a runner.
+func RedactSecretsInValue(request *plugin.ExecuteActionRequest, value interface{}) interface{} {
+	values := redactedValues(request)
+	if len(values) == 0 {
+		return value
+	}
+
+	redacted, _ := redactValue(value, values)
+	return redacted
+}
+
+// RedactOutput masks the secret values of the request in the output of a step. Json documents are redacted
+// after decoding them, secrets can appear escaped in json strings and masking the raw bytes may break the document.
+func RedactOutput(request *plugin.ExecuteActionRequest, output []byte) []byte {
+	values := redactedValues(request)
+	if len(values) == 0 || len(output) == 0 {
+		return output
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	if !json.Valid(output) || decoder.Decode(&document) != nil {
+		return RedactSecrets(request, output)
+	}
+
+	redacted, changed := redactValue(document, values)
+	if !changed {
+		return output
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redacted); err != nil {
+		return RedactSecrets(request, output)
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+}
+
+// redactedValues are the secret values of the request which are masked, including each line of multi line secrets.
+func redactedValues(request *plugin.ExecuteActionRequest) []string {
+	var values []string
+	for _, value := range getRequestSecrets(request) {
+		candidates := []string{value}
+		// multi line secrets (e.g. keys) may be printed partially, mask each line as well
+		if strings.Contains(value, "\n") {
+			candidates = append(candidates, strings.Split(value, "\n")...)
+		}
+
+		for _, candidate := range candidates {
+			candidate = strings.TrimSpace(candidate)
+			if len(candidate) >= minRedactedSecretLength {
+				values = append(values, candidate)
+			}
+		}
+	}
+	return values
+}
+
+func redactString(value string, values []string) (string, bool) {
+	redacted := value
+	for _, secret := range values {
+		redacted = strings.ReplaceAll(redacted, secret, redactedSecret)
+	}
+	return redacted, redacted != value
+}
+
+func redactValue(value interface{}, values []string) (interface{}, bool) {
+	changed := false
+	switch typedValue := value.(type) {
+	case string:
+		return redactString(typedValue, values)
+	case json.Number:
+		if redacted, ok := redactString(typedValue.String(), values); ok {
+			return redacted, true
+		}
+	case map[string]interface{}:
+		for key, entry := range typedValue {
+			if redacted, ok := redactValue(entry, values); ok {
+				typedValue[key] = redacted
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, entry := range typedValue {
+			if redacted, ok := redactValue(entry, values); ok {
+				typedValue[i] = redacted
+				changed = true
+			}
+		}
+	}
+	return value, changed
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	request := &plugin.ExecuteActionRequest{Name: "bash"}
+	otherRequest := &plugin.ExecuteActionRequest{Name: "bash"}
+
+	RegisterRequestSecrets(request, map[string]string{
+		"DB_PASSWORD": "hunter2-password",
+		"SHORT":       "yes",
+		"TLS_KEY":     "-----BEGIN KEY-----\nc2VjcmV0LWtleQ==\n-----END KEY-----",
+	})
+	defer UnregisterRequestSecrets(request)
+
+	output := []byte("password=hunter2-password ok=yes\nkey: c2VjcmV0LWtleQ==")
+	assert.Equal(t, "password=*** ok=yes\nkey: ***", string(RedactSecrets(request, output)))
+	assert.Equal(t, string(output), string(RedactSecrets(otherRequest, output)))
+
+	assert.ElementsMatch(t, []string{
+		"DB_PASSWORD=hunter2-password",
+		"SHORT=yes",
+		"TLS_KEY=-----BEGIN KEY-----\nc2VjcmV0LWtleQ==\n-----END KEY-----",
+	}, requestSecretEnvironment(request))
+
+	UnregisterRequestSecrets(request)
+	assert.Empty(t, requestSecretEnvironment(request))
+}
+
+func TestRedactOutput(t *testing.T) {
+	request := &plugin.ExecuteActionRequest{Name: "python"}
+	RegisterRequestSecrets(request, map[string]string{
+		"QUOTED":  `pa"ss\word`,
+		"KEYWORD": "true",
+		"NUMBER":  "123456",
+	})
+	defer UnregisterRequestSecrets(request)
+
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{
+			name:     "escaped secret in a json string",
+			output:   `{"enabled": true, "password": "pa\"ss\\word"}`,
+			expected: `{"enabled":true,"password":"***"}`,
+		},
+		{
+			name:     "secrets in nested values and numbers",
+			output:   `[{"id": 123456, "tags": ["true", "x-123456-y"]}]`,
+			expected: `[{"id":"***","tags":["***","x-***-y"]}]`,
+		},
+		{
+			name:     "json without secrets is kept as is",
+			output:   "{\n  \"enabled\": true\n}",
+			expected: "{\n  \"enabled\": true\n}",
+		},
+		{
+			name:     "plain text",
+			output:   "pa\"ss\\word is true",
+			expected: "*** is ***",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, string(RedactOutput(request, []byte(tt.output))))
+		})
+	}
+
+	assert.Equal(t,
+		map[string]interface{}{"user": "admin", "password": "***", "list": []interface{}{"***", 1.5}},
+		RedactSecretsInValue(request, map[string]interface{}{"user": "admin", "password": `pa"ss\word`, "list": []interface{}{"true", 1.5}}))
+}
@@ -0,0 +1,145 @@
+//go:build ignore
+// +build ignore
+
+// The go action runner. It is compiled together with the user code, which has to be in package main and define:
+//
+//	func run(context map[string]interface{}, connections map[string]map[string]string) (interface{}, error)
+//
+// Whatever the code prints, to stdout or stderr, goes to the log, the returned value is the output of the step and the
+// context map (including any changes made by the code) is written back to the execution.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+type runnerInput struct {
+	Code                string                       `json:"code"`
+	Context             map[string]interface{}       `json:"context"`
+	ResolvedConnections map[string]map[string]string `json:"resolved_connections"`
+}
+
+type runnerOutput struct {
+	Context map[string]interface{} `json:"context"`
+	Log     string                 `json:"log"`
+	Output  string                 `json:"output"`
+	Error   string                 `json:"error"`
+}
+
+func main() {
+	inputFile := flag.String("input", "", "File location containing the raw marshaled input json struct")
+	flag.Parse()
+
+	// the result is written to a copy of stdout, the code only ever sees the log pipe on fd 1 and 2
+	resultFd, err := syscall.Dup(syscall.Stdout)
+	if err != nil {
+		fmt.Printf(`{"error": "failed duplicating stdout: %v"}`, err)
+		return
+	}
+	resultOutput := os.NewFile(uintptr(resultFd), "result")
+	result := runnerOutput{}
+	defer func() {
+		_ = json.NewEncoder(resultOutput).Encode(result)
+	}()
+
+	rawInput, err := ioutil.ReadFile(*inputFile)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed reading the runner input: %v", err)
+		return
+	}
+
+	input := runnerInput{}
+	if err = json.Unmarshal(rawInput, &input); err != nil {
+		result.Error = fmt.Sprintf("failed decoding the runner input: %v", err)
+		return
+	}
+
+	if input.Context == nil {
+		input.Context = map[string]interface{}{}
+	}
+
+	result.Log, result.Output, result.Error = runUserCode(input)
+	result.Context = input.Context
+}
+
+func runUserCode(input runnerInput) (log string, output string, errorMessage string) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return "", "", fmt.Sprintf("failed capturing the code output: %v", err)
+	}
+
+	logBuffer := &bytes.Buffer{}
+	copyDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(logBuffer, reader)
+		close(copyDone)
+	}()
+
+	// redirecting the file descriptors also captures the log package and anything the runtime writes
+	restore, err := redirectStandardStreams(writer)
+	if err != nil {
+		_ = writer.Close()
+		return "", "", fmt.Sprintf("failed capturing the code output: %v", err)
+	}
+	defer func() {
+		restore()
+		_ = writer.Close()
+		<-copyDone
+		log = logBuffer.String()
+
+		if recovered := recover(); recovered != nil {
+			errorMessage = fmt.Sprintf("User provided code panicked: %v", recovered)
+		}
+	}()
+
+	value, err := run(input.Context, input.ResolvedConnections)
+	if err != nil {
+		return "", "", fmt.Sprintf("User provided code returned an error: %v", err)
+	}
+
+	switch typedValue := value.(type) {
+	case nil:
+		return "", "", ""
+	case string:
+		return "", typedValue, ""
+	default:
+		marshaledValue, err := json.Marshal(typedValue)
+		if err != nil {
+			return "", "", fmt.Sprintf("failed marshaling the returned value: %v", err)
+		}
+		return "", string(marshaledValue), ""
+	}
+}
+
+// redirectStandardStreams points stdout and stderr at the file and returns a function restoring them.
+func redirectStandardStreams(file *os.File) (func(), error) {
+	var saved []int
+	restore := func() {
+		for stream, fd := range saved {
+			_ = syscall.Dup3(fd, stream+1, 0)
+			_ = syscall.Close(fd)
+		}
+	}
+
+	for _, stream := range []int{syscall.Stdout, syscall.Stderr} {
+		fd, err := syscall.Dup(stream)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		saved = append(saved, fd)
+
+		if err = syscall.Dup3(int(file.Fd()), stream, 0); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	return restore, nil
+}
@@ -0,0 +1,362 @@
+package implementation
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	regionsParameterName         = "Regions"
+	accountsParameterName        = "Accounts"
+	accountRoleNameParameterName = "Account Role Name"
+	concurrencyParameterName     = "Concurrency"
+
+	allRegions               = "all"
+	organizationAccounts     = "organization"
+	defaultFanOutConcurrency = 5
+	maxFanOutConcurrency     = 50
+)
+
+var accountIdPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// fanOutResult is the result of running the command of a step against a single target, e.g. a region.
+type fanOutResult struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runFanOut runs the function for every target with at most concurrency targets at a time.
+func runFanOut(targets []string, concurrency int, run func(target string) ([]byte, error)) map[string]fanOutResult {
+	results := make(map[string]fanOutResult, len(targets))
+	var resultsMutex sync.Mutex
+
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(target string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			output, err := run(target)
+			result := fanOutResult{Output: strings.TrimSuffix(string(output), "\n")}
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			resultsMutex.Lock()
+			results[target] = result
+			resultsMutex.Unlock()
+		}(target)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fanOutResponse marshals the results, the step fails only when the command failed for every target.
+func fanOutResponse(results map[string]fanOutResult) ([]byte, error) {
+	response, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Error == "" {
+			return response, nil
+		}
+	}
+	return response, common.CLIError
+}
+
+func getFanOutConcurrency(request *plugin.ExecuteActionRequest) (int, error) {
+	rawConcurrency := request.Parameters[concurrencyParameterName]
+	if rawConcurrency == "" {
+		return defaultFanOutConcurrency, nil
+	}
+
+	concurrency, err := strconv.Atoi(rawConcurrency)
+	if err != nil || concurrency < 1 || concurrency > maxFanOutConcurrency {
+		return 0, errors.Errorf("concurrency must be between 1 and %d", maxFanOutConcurrency)
+	}
+	return concurrency, nil
+}
+
+// executeAwsRegionFanOut runs the command of the step once per region with the CLI user which was already set up,
+// so the credentials are resolved once and shared by all regions.
+func executeAwsRegionFanOut(e *execution.PrivateExecutionEnvironment, request *plugin.ExecuteActionRequest, environment []string, credentials map[string]string, region string, command string) ([]byte, error) {
+	concurrency, err := getFanOutConcurrency(request)
+	if err != nil {
+		return nil, err
+	}
+
+	regions, err := resolveFanOutRegions(request.Parameters[regionsParameterName], func() (ec2iface.EC2API, error) {
+		sess, err := newFanOutSession(credentials, region)
+		if err != nil {
+			return nil, err
+		}
+		return ec2.New(sess), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = enforceFanOutPolicies(request, command, regionParameterName, regions); err != nil {
+		return nil, err
+	}
+
+	results := runFanOut(regions, concurrency, func(target string) ([]byte, error) {
+		regionEnvironment := append([]string{
+			fmt.Sprintf("AWS_REGION=%s", target),
+			fmt.Sprintf("AWS_DEFAULT_REGION=%s", target),
+		}, environment...)
+
+		output, err := common.ExecuteCommand(e, request, regionEnvironment, "/bin/bash", "-c", command)
+		if err != nil {
+			return output, errors.Wrap(err, "command failed")
+		}
+		return output, nil
+	})
+
+	return fanOutResponse(results)
+}
+
+// resolveFanOutRegions parses the comma separated regions of the step, "all" stands for the enabled regions of the account.
+func resolveFanOutRegions(rawRegions string, ec2Client func() (ec2iface.EC2API, error)) ([]string, error) {
+	var regions []string
+	seen := map[string]bool{}
+	for _, region := range strings.FieldsFunc(rawRegions, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+		if region == allRegions {
+			svc, err := ec2Client()
+			if err != nil {
+				return nil, err
+			}
+			return describeEnabledRegions(svc)
+		}
+
+		if !seen[region] {
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+
+	if len(regions) == 0 {
+		return nil, errors.New("no regions were provided")
+	}
+	return regions, nil
+}
+
+func describeEnabledRegions(svc ec2iface.EC2API) ([]string, error) {
+	// without AllRegions only the regions which are enabled for the account are returned
+	result, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{AllRegions: aws.Bool(false)})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed describing the enabled regions")
+	}
+
+	regions := make([]string, 0, len(result.Regions))
+	for _, region := range result.Regions {
+		regions = append(regions, aws.StringValue(region.RegionName))
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// newFanOutSession uses the resolved credentials of the step, never the identity of the plugin.
+func newFanOutSession(credentials map[string]string, region string) (*session.Session, error) {
+	if credentials[awsAccessKeyId] == "" || credentials[awsSecretAccessKey] == "" {
+		return nil, errors.New("fanning out requires an aws connection whose credentials were resolved")
+	}
+	return newAwsSession(credentials, region)
+}
+
+// executeAwsAccountFanOut runs the command of the step once per account. The role of every account is assumed
+// with the credentials of the connection and handed to the CLI user as a named profile of the account.
+func executeAwsAccountFanOut(e *execution.PrivateExecutionEnvironment, ce *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, environment []string, credentials map[string]string, region string, profiles map[string]awsProfile, command string) ([]byte, error) {
+	concurrency, err := getFanOutConcurrency(request)
+	if err != nil {
+		return nil, err
+	}
+
+	roleName := request.Parameters[accountRoleNameParameterName]
+	if roleName == "" {
+		return nil, errors.New("a role name to assume in each account is required")
+	}
+
+	sess, err := newFanOutSession(credentials, region)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := resolveFanOutAccounts(request.Parameters[accountsParameterName], func() organizationsiface.OrganizationsAPI {
+		return organizations.New(sess)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := getAssumeRoleOptions(ctx, request, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	accountCredentials, assumeResults := assumeAccountRoles(sts.New(sess), accounts, accountRoleArnPrefix(region), roleName, options, concurrency)
+
+	if err = writeAwsFiles(ce, credentials, region, profiles, accountCredentials); err != nil {
+		return nil, err
+	}
+
+	var assumedAccounts []string
+	for _, account := range accounts {
+		if _, ok := accountCredentials[account]; ok {
+			assumedAccounts = append(assumedAccounts, account)
+		}
+	}
+
+	results := runFanOut(assumedAccounts, concurrency, func(target string) ([]byte, error) {
+		accountEnvironment := append([]string{fmt.Sprintf("AWS_PROFILE=%s", accountProfileName(target))}, environment...)
+
+		output, err := common.ExecuteCommand(e, request, accountEnvironment, "/bin/bash", "-c", command)
+		if err != nil {
+			return output, errors.Wrap(err, "command failed")
+		}
+		return output, nil
+	})
+	for account, result := range assumeResults {
+		results[account] = result
+	}
+
+	return fanOutResponse(results)
+}
+
+// resolveFanOutAccounts parses the comma separated account ids of the step, "organization" stands for the active
+// accounts of the organization.
+func resolveFanOutAccounts(rawAccounts string, organizationsClient func() organizationsiface.OrganizationsAPI) ([]string, error) {
+	var accounts []string
+	seen := map[string]bool{}
+	for _, account := range strings.FieldsFunc(rawAccounts, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+		if account == organizationAccounts {
+			return listOrganizationAccounts(organizationsClient())
+		}
+
+		if !accountIdPattern.MatchString(account) {
+			return nil, errors.Errorf("invalid aws account id: %s", account)
+		}
+		if !seen[account] {
+			seen[account] = true
+			accounts = append(accounts, account)
+		}
+	}
+
+	if len(accounts) == 0 {
+		return nil, errors.New("no accounts were provided")
+	}
+	return accounts, nil
+}
+
+func listOrganizationAccounts(svc organizationsiface.OrganizationsAPI) ([]string, error) {
+	var accounts []string
+	err := svc.ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, _ bool) bool {
+		for _, account := range page.Accounts {
+			if aws.StringValue(account.Status) == organizations.AccountStatusActive {
+				accounts = append(accounts, aws.StringValue(account.Id))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing the accounts of the organization")
+	}
+
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// assumeAccountRoles assumes the role in every account, accounts which failed are returned with their error.
+func assumeAccountRoles(svc stsiface.STSAPI, accounts []string, roleArnPrefix string, roleName string, options assumeRoleOptions, concurrency int) (map[string]map[string]string, map[string]fanOutResult) {
+	accountCredentials := map[string]map[string]string{}
+	var credentialsMutex sync.Mutex
+
+	results := runFanOut(accounts, concurrency, func(account string) ([]byte, error) {
+		role := fmt.Sprintf("%s%s:role/%s", roleArnPrefix, account, roleName)
+		access, secret, sessionToken, err := assumeRoleWithTrustedIdentity(svc, role, "", options)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed assuming %s", role)
+		}
+
+		credentialsMutex.Lock()
+		accountCredentials[account] = map[string]string{
+			awsAccessKeyId:     access,
+			awsSecretAccessKey: secret,
+			awsSessionToken:    sessionToken,
+		}
+		credentialsMutex.Unlock()
+		return nil, nil
+	})
+
+	failed := map[string]fanOutResult{}
+	for account, result := range results {
+		if result.Error != "" {
+			failed[account] = result
+		}
+	}
+	return accountCredentials, failed
+}
+
+// accountRoleArnPrefix returns the role arn prefix of the partition of the region, e.g. arn:aws-us-gov:iam::
+func accountRoleArnPrefix(region string) string {
+	partition := endpoints.AwsPartitionID
+	if regionPartition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		partition = regionPartition.ID()
+	}
+	return fmt.Sprintf("arn:%s:iam::", partition)
+}
+
+func accountProfileName(account string) string {
+	return "account-" + account
+}
+
+// buildAwsAccountProfiles builds a profile per account, which writeAwsFiles adds to the aws files of the CLI user.
+func buildAwsAccountProfiles(accountCredentials map[string]map[string]string, region string) (string, string) {
+	if len(accountCredentials) == 0 {
+		return "", ""
+	}
+
+	accounts := make([]string, 0, len(accountCredentials))
+	for account := range accountCredentials {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	var credentialsLines, configLines []string
+	for _, account := range accounts {
+		credentials := accountCredentials[account]
+		credentialsLines = append(credentialsLines, "", fmt.Sprintf("[%s]", accountProfileName(account)),
+			fmt.Sprintf("%s = %s", awsAccessKeyId, credentials[awsAccessKeyId]),
+			fmt.Sprintf("%s = %s", awsSecretAccessKey, credentials[awsSecretAccessKey]),
+			fmt.Sprintf("%s = %s", awsSessionToken, credentials[awsSessionToken]),
+		)
+		configLines = append(configLines, "", fmt.Sprintf("[profile %s]", accountProfileName(account)), fmt.Sprintf("region = %s", region))
+	}
+
+	return strings.Join(credentialsLines, "\n") + "\n", strings.Join(configLines, "\n") + "\n"
+}
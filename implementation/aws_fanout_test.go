@@ -0,0 +1,112 @@
+package implementation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/blinkops/blink-core/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type EC2MockClient struct {
+	ec2iface.EC2API
+}
+
+func (c *EC2MockClient) DescribeRegions(input *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	if aws.BoolValue(input.AllRegions) {
+		return nil, fmt.Errorf("only enabled regions should be described")
+	}
+	return &ec2.DescribeRegionsOutput{
+		Regions: []*ec2.Region{
+			{RegionName: aws.String("us-west-2")},
+			{RegionName: aws.String("eu-west-1")},
+			{RegionName: aws.String("us-east-1")},
+		},
+	}, nil
+}
+
+func TestResolveFanOutRegions(t *testing.T) {
+	client := func() (ec2iface.EC2API, error) { return &EC2MockClient{}, nil }
+
+	regions, err := resolveFanOutRegions("us-east-1, eu-west-1,us-east-1", client)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1", "eu-west-1"}, regions)
+
+	regions, err = resolveFanOutRegions("all", client)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1", "us-east-1", "us-west-2"}, regions)
+
+	_, err = resolveFanOutRegions(" , ", client)
+	assert.EqualError(t, err, "no regions were provided")
+}
+
+func TestRunFanOut(t *testing.T) {
+	var running, maxRunning int32
+	results := runFanOut([]string{"a", "b", "c", "d", "e"}, 2, func(target string) ([]byte, error) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			observed := atomic.LoadInt32(&maxRunning)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		if target == "c" {
+			return []byte("denied\n"), fmt.Errorf("command failed")
+		}
+		return []byte(target + "\n"), nil
+	})
+
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	assert.Len(t, results, 5)
+	assert.Equal(t, fanOutResult{Output: "a"}, results["a"])
+	assert.Equal(t, fanOutResult{Output: "denied", Error: "command failed"}, results["c"])
+}
+
+func TestFanOutResponse(t *testing.T) {
+	response, err := fanOutResponse(map[string]fanOutResult{
+		"us-east-1": {Output: "ok"},
+		"eu-west-1": {Error: "command failed"},
+	})
+	require.NoError(t, err)
+
+	parsed := map[string]fanOutResult{}
+	require.NoError(t, json.Unmarshal(response, &parsed))
+	assert.Equal(t, "command failed", parsed["eu-west-1"].Error)
+
+	_, err = fanOutResponse(map[string]fanOutResult{"eu-west-1": {Error: "command failed"}})
+	assert.Equal(t, common.CLIError, err)
+}
+
+func TestNewFanOutSessionRequiresCredentials(t *testing.T) {
+	for _, credentials := range []map[string]string{nil, {roleArn: "arn:aws:iam::123456789012:role/blink"}, {awsAccessKeyId: "AKID"}} {
+		_, err := newFanOutSession(credentials, "us-east-1")
+		assert.Error(t, err)
+	}
+
+	_, err := newFanOutSession(map[string]string{awsAccessKeyId: "AKID", awsSecretAccessKey: "SECRET"}, "us-east-1")
+	assert.NoError(t, err)
+}
+
+func TestBuildAwsAccountProfiles(t *testing.T) {
+	credentialsContent, configContent := buildAwsAccountProfiles(nil, "us-east-1")
+	assert.Empty(t, credentialsContent)
+	assert.Empty(t, configContent)
+
+	credentialsContent, configContent = buildAwsAccountProfiles(map[string]map[string]string{
+		"222222222222": {awsAccessKeyId: "AKID2", awsSecretAccessKey: "SECRET2", awsSessionToken: "TOKEN2"},
+		"111111111111": {awsAccessKeyId: "AKID1", awsSecretAccessKey: "SECRET1", awsSessionToken: "TOKEN1"},
+	}, "us-east-1")
+	assert.Equal(t, "\n[account-111111111111]\naws_access_key_id = AKID1\naws_secret_access_key = SECRET1\naws_session_token = TOKEN1"+
+		"\n\n[account-222222222222]\naws_access_key_id = AKID2\naws_secret_access_key = SECRET2\naws_session_token = TOKEN2\n", credentialsContent)
+	assert.Equal(t, "\n[profile account-111111111111]\nregion = us-east-1\n\n[profile account-222222222222]\nregion = us-east-1\n", configContent)
+}
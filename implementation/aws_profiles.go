@@ -0,0 +1,120 @@
+package implementation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	profilesParameterName = "Profiles"
+	credentialProcess     = "credential_process"
+	defaultAwsProfile     = "default"
+)
+
+var awsProfileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// awsProfile is a named profile of the aws config file. A profile with a role assumes it with the credentials of
+// its source profile, which is either the default profile (the connection) or another named profile, so roles
+// can be chained. The aws CLI assumes the roles itself and refreshes them when they expire.
+type awsProfile struct {
+	RoleArn         string `yaml:"role_arn"`
+	SourceProfile   string `yaml:"source_profile"`
+	ExternalID      string `yaml:"external_id"`
+	Region          string `yaml:"region"`
+	DurationSeconds int    `yaml:"duration_seconds"`
+	RoleSessionName string `yaml:"role_session_name"`
+}
+
+func getAwsProfiles(request *plugin.ExecuteActionRequest) (map[string]awsProfile, error) {
+	return parseAwsProfiles(request.Parameters[profilesParameterName])
+}
+
+// parseAwsProfiles parses the yaml mapping of profile names to profiles and validates the source profile chains.
+func parseAwsProfiles(rawProfiles string) (map[string]awsProfile, error) {
+	profiles := map[string]awsProfile{}
+	if strings.TrimSpace(rawProfiles) == "" {
+		return profiles, nil
+	}
+
+	if err := yaml.UnmarshalStrict([]byte(rawProfiles), &profiles); err != nil {
+		return nil, errors.Wrap(err, "failed parsing aws profiles")
+	}
+
+	for name, profile := range profiles {
+		if name == defaultAwsProfile || !awsProfileNamePattern.MatchString(name) {
+			return nil, errors.Errorf("invalid aws profile name: %s", name)
+		}
+		if profile.RoleArn == "" {
+			return nil, errors.Errorf("aws profile %s is missing a role_arn", name)
+		}
+		for _, value := range []string{profile.RoleArn, profile.SourceProfile, profile.ExternalID, profile.Region, profile.RoleSessionName} {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, errors.Errorf("invalid value in aws profile %s", name)
+			}
+		}
+
+		// walk the chain of source profiles down to the default profile
+		visited := map[string]bool{name: true}
+		for source := profile.SourceProfile; source != "" && source != defaultAwsProfile; source = profiles[source].SourceProfile {
+			if _, ok := profiles[source]; !ok {
+				return nil, errors.Errorf("aws profile %s has an unknown source profile: %s", name, source)
+			}
+			if visited[source] {
+				return nil, errors.Errorf("aws profile %s has a cyclic source profile chain", name)
+			}
+			visited[source] = true
+		}
+	}
+
+	return profiles, nil
+}
+
+// buildAwsConfigFile returns the aws config file with the default profile and the named profiles of the step.
+func buildAwsConfigFile(credentials map[string]string, region string, profiles map[string]awsProfile) string {
+	lines := []string{"[default]", fmt.Sprintf("region = %s", region)}
+	if process := credentials[credentialProcess]; process != "" {
+		lines = append(lines, fmt.Sprintf("%s = %s", credentialProcess, process))
+	}
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := profiles[name]
+
+		sourceProfile := profile.SourceProfile
+		if sourceProfile == "" {
+			sourceProfile = defaultAwsProfile
+		}
+		profileRegion := profile.Region
+		if profileRegion == "" {
+			profileRegion = region
+		}
+
+		lines = append(lines, "", fmt.Sprintf("[profile %s]", name),
+			fmt.Sprintf("role_arn = %s", profile.RoleArn),
+			fmt.Sprintf("source_profile = %s", sourceProfile),
+			fmt.Sprintf("region = %s", profileRegion),
+		)
+		if profile.ExternalID != "" {
+			lines = append(lines, fmt.Sprintf("external_id = %s", profile.ExternalID))
+		}
+		if profile.DurationSeconds != 0 {
+			lines = append(lines, fmt.Sprintf("duration_seconds = %d", profile.DurationSeconds))
+		}
+		if profile.RoleSessionName != "" {
+			lines = append(lines, fmt.Sprintf("role_session_name = %s", profile.RoleSessionName))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
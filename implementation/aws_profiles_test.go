@@ -0,0 +1,95 @@
+package implementation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAwsProfiles(t *testing.T) {
+	profiles, err := parseAwsProfiles(`
+source:
+  role_arn: arn:aws:iam::111111111111:role/source
+  external_id: blink
+target:
+  role_arn: arn:aws:iam::222222222222:role/target
+  source_profile: source
+  region: eu-west-1
+  duration_seconds: 7200
+`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]awsProfile{
+		"source": {RoleArn: "arn:aws:iam::111111111111:role/source", ExternalID: "blink"},
+		"target": {RoleArn: "arn:aws:iam::222222222222:role/target", SourceProfile: "source", Region: "eu-west-1", DurationSeconds: 7200},
+	}, profiles)
+
+	profiles, err = parseAwsProfiles("")
+	require.NoError(t, err)
+	assert.Empty(t, profiles)
+
+	tests := []struct {
+		name     string
+		profiles string
+		wantErr  string
+	}{
+		{
+			name:     "unknown field",
+			profiles: "a:\n  role_arn: arn\n  mfa_serial: arn",
+			wantErr:  "failed parsing aws profiles",
+		},
+		{
+			name:     "default profile",
+			profiles: "default:\n  role_arn: arn",
+			wantErr:  "invalid aws profile name: default",
+		},
+		{
+			name:     "missing role",
+			profiles: "a:\n  region: us-east-1",
+			wantErr:  "aws profile a is missing a role_arn",
+		},
+		{
+			name:     "unknown source profile",
+			profiles: "a:\n  role_arn: arn\n  source_profile: b",
+			wantErr:  "aws profile a has an unknown source profile: b",
+		},
+		{
+			name:     "cyclic source profiles",
+			profiles: "a:\n  role_arn: arn\n  source_profile: b\nb:\n  role_arn: arn\n  source_profile: a",
+			wantErr:  "has a cyclic source profile chain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAwsProfiles(tt.profiles)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestBuildAwsConfigFile(t *testing.T) {
+	profiles := map[string]awsProfile{
+		"target": {RoleArn: "arn:aws:iam::222222222222:role/target", SourceProfile: "source", DurationSeconds: 7200},
+		"source": {RoleArn: "arn:aws:iam::111111111111:role/source", ExternalID: "blink", Region: "eu-west-1"},
+	}
+
+	config := buildAwsConfigFile(map[string]string{credentialProcess: "/usr/local/bin/creds --json"}, "us-east-1", profiles)
+	assert.Equal(t, `[default]
+region = us-east-1
+credential_process = /usr/local/bin/creds --json
+
+[profile source]
+role_arn = arn:aws:iam::111111111111:role/source
+source_profile = default
+region = eu-west-1
+external_id = blink
+
+[profile target]
+role_arn = arn:aws:iam::222222222222:role/target
+source_profile = source
+region = us-east-1
+duration_seconds = 7200
+`, config)
+}
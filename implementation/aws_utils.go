@@ -1,22 +1,55 @@
 package implementation
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	awsCredentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	roleSessionName   = "role_session_name"
+	sessionDuration   = "duration_seconds"
+	sessionTags       = "session_tags"
+	transitiveTagKeys = "transitive_tag_keys"
+	sessionPolicy     = "session_policy"
+
+	sessionDurationParameterName   = "Session Duration"
+	sessionTagsParameterName       = "Session Tags"
+	transitiveTagKeysParameterName = "Transitive Tag Keys"
+	sessionPolicyParameterName     = "Session Policy"
+
+	defaultSessionDurationSeconds = 3600
+	minSessionDurationSeconds     = 900
+	maxSessionDurationSeconds     = 43200
+	maxRoleSessionNameLength      = 64
+)
+
+var invalidRoleSessionNameCharacters = regexp.MustCompile(`[^\w+=,.@\-]`)
+
 // access keys have to be both set
 // role arn can be supplied alone if it's irsa
 // role arn and external id have to be supplied together for traditional assume role
+// a credential process takes precedence over everything else
 func detectConnectionType(awsCredentials map[string]string) (credsType, key, value string) {
+	if awsCredentials[credentialProcess] != "" {
+		return "processBased", awsCredentials[credentialProcess], ""
+	}
 	if awsCredentials[awsAccessKeyId] == "" || awsCredentials[awsSecretAccessKey] == "" {
 		if awsCredentials[roleArn] == "" {
 			return "", "", ""
@@ -34,7 +67,135 @@ func readFile(path string) (string, error) {
 	return string(data), nil
 }
 
-func assumeRoleWithWebIdentity(svc stsiface.STSAPI, role, sessionName string) (string, string, string, error) {
+// assumeRoleOptions are the settings of the role session, set by the aws connection and overridden by the action.
+type assumeRoleOptions struct {
+	SessionName       string
+	DurationSeconds   int64
+	Tags              map[string]string
+	TransitiveTagKeys []string
+	Policy            string
+}
+
+func getAssumeRoleOptions(ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, credentials map[string]string) (assumeRoleOptions, error) {
+	options := assumeRoleOptions{SessionName: credentials[roleSessionName]}
+	if options.SessionName == "" {
+		if executionId, ok := ctx.GetAllContextEntries()["execution_id"].(string); ok {
+			options.SessionName = executionId
+		}
+	}
+	options.SessionName = sanitizeRoleSessionName(options.SessionName)
+
+	setting := func(connectionKey string, parameterName string) string {
+		if request != nil && request.Parameters[parameterName] != "" {
+			return request.Parameters[parameterName]
+		}
+		return credentials[connectionKey]
+	}
+
+	if duration := setting(sessionDuration, sessionDurationParameterName); duration != "" {
+		seconds, err := strconv.ParseInt(duration, 10, 64)
+		if err != nil || seconds < minSessionDurationSeconds || seconds > maxSessionDurationSeconds {
+			return options, errors.Errorf("session duration must be between %d and %d seconds", minSessionDurationSeconds, maxSessionDurationSeconds)
+		}
+		options.DurationSeconds = seconds
+	}
+
+	if tags := setting(sessionTags, sessionTagsParameterName); tags != "" {
+		if err := json.Unmarshal([]byte(tags), &options.Tags); err != nil {
+			return options, errors.Wrap(err, "session tags must be a json object of strings")
+		}
+	}
+
+	for _, key := range strings.Split(setting(transitiveTagKeys, transitiveTagKeysParameterName), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			options.TransitiveTagKeys = append(options.TransitiveTagKeys, key)
+		}
+	}
+
+	if policy := setting(sessionPolicy, sessionPolicyParameterName); policy != "" {
+		if !json.Valid([]byte(policy)) {
+			return options, errors.New("session policy must be a json policy document")
+		}
+		options.Policy = policy
+	}
+
+	return options, nil
+}
+
+// resolveAwsConnection assumes the role of the aws connection with the session options of the step, the connection
+// itself isn't changed. Credentials are nil without a connection. It fails when the session policy or tags of the
+// step can't be applied, assumesRoles tells that the step assumes further roles with the options itself. With
+// fallBack a connection which fails to resolve is logged and the step runs without credentials, unless the step
+// restricts its session, the identity of the plugin wouldn't be restricted.
+func resolveAwsConnection(ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, credentials map[string]string, region string, profiles map[string]awsProfile, assumesRoles bool, fallBack bool) (map[string]string, error) {
+	options, err := getAssumeRoleOptions(ctx, request, credentials)
+	if err != nil {
+		return nil, err
+	}
+	connectionType, _, connectionExternalID := detectConnectionType(credentials)
+	assumesRole := connectionType == "roleBased" || assumesRoles
+	// a role without an external id is assumed with the web identity of the plugin, which can't take session tags
+	appliesTags := (connectionType == "roleBased" && connectionExternalID != "") || assumesRoles
+	if err = checkSessionRestrictions(options, assumesRole, appliesTags, profiles); err != nil {
+		return nil, err
+	}
+	if credentials == nil {
+		return nil, nil
+	}
+
+	// resolveAwsCreds fills the assumed role credentials in place
+	resolved := make(map[string]string, len(credentials))
+	for key, value := range credentials {
+		resolved[key] = value
+	}
+	if resolved, err = resolveAwsCreds(resolved, region, options); err != nil {
+		if fallBack && !options.restricted() {
+			log.Warnf("failed resolving aws credentials, will try without credentials: %v", err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// restricted tells whether the session is narrowed down by a policy or tags.
+func (o assumeRoleOptions) restricted() bool {
+	return o.Policy != "" || len(o.Tags) > 0 || len(o.TransitiveTagKeys) > 0
+}
+
+// checkSessionRestrictions fails when the session policy or tags can't be applied, because no role is assumed, the
+// role is assumed with a web identity which can't take tags, or the CLI assumes the roles of the named profiles
+// without them. Dropping them would run the step with wider access.
+func checkSessionRestrictions(options assumeRoleOptions, assumesRole bool, appliesTags bool, profiles map[string]awsProfile) error {
+	if !options.restricted() {
+		return nil
+	}
+	hasTags := len(options.Tags) > 0 || len(options.TransitiveTagKeys) > 0
+	if !assumesRole {
+		return errors.New("a session policy or session tags require a connection with a role_arn, these credentials assume no role")
+	}
+	if hasTags && !appliesTags {
+		return errors.New("session tags require a connection with an external_id, a web identity carries its tags in its token")
+	}
+	if len(profiles) > 0 {
+		return errors.New("a session policy or session tags can't be combined with profiles, the CLI assumes their roles without them")
+	}
+	return nil
+}
+
+// sanitizeRoleSessionName keeps the characters sts accepts in a role session name ([\w+=,.@-], 2 to 64 characters).
+func sanitizeRoleSessionName(name string) string {
+	name = invalidRoleSessionNameCharacters.ReplaceAllString(name, "-")
+	if len(name) > maxRoleSessionNameLength {
+		name = name[:maxRoleSessionNameLength]
+	}
+	if len(name) < 2 {
+		return ""
+	}
+	return name
+}
+
+func assumeRoleWithWebIdentity(svc stsiface.STSAPI, role string, options assumeRoleOptions) (string, string, string, error) {
 	log.Debug("assuming role with web identity")
 	tokenFile, ok := os.LookupEnv("AWS_WEB_IDENTITY_TOKEN_FILE")
 	if !ok {
@@ -46,37 +207,129 @@ func assumeRoleWithWebIdentity(svc stsiface.STSAPI, role, sessionName string) (s
 		return "", "", "", fmt.Errorf("unable to open web identity token file with error: %w", err)
 	}
 
-	result, err := svc.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
-		DurationSeconds:  aws.Int64(3600),
+	// session tags of a web identity come from the token itself, sts doesn't accept them here
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		DurationSeconds:  aws.Int64(defaultSessionDurationSeconds),
 		RoleArn:          aws.String(role),
-		RoleSessionName:  aws.String(sessionName),
+		RoleSessionName:  aws.String(options.SessionName),
 		WebIdentityToken: aws.String(string(data)),
-	})
+	}
+	if options.DurationSeconds != 0 {
+		input.DurationSeconds = aws.Int64(options.DurationSeconds)
+	}
+	if options.Policy != "" {
+		input.Policy = aws.String(options.Policy)
+	}
+
+	result, err := svc.AssumeRoleWithWebIdentity(input)
 	if err != nil {
 		return "", "", "", err
 	}
 	return *result.Credentials.AccessKeyId, *result.Credentials.SecretAccessKey, *result.Credentials.SessionToken, err
 }
 
-func assumeRoleWithTrustedIdentity(svc stsiface.STSAPI, role, externalID, sessionName string) (string, string, string, error) {
+func assumeRoleWithTrustedIdentity(svc stsiface.STSAPI, role, externalID string, options assumeRoleOptions) (string, string, string, error) {
 	log.Debug("assuming role with trusted entity")
-	result, err := svc.AssumeRole(&sts.AssumeRoleInput{
-		RoleArn:         &role,
-		RoleSessionName: &sessionName,
-		ExternalId:      &externalID,
-	})
+	input := &sts.AssumeRoleInput{
+		RoleArn:           &role,
+		RoleSessionName:   &options.SessionName,
+		TransitiveTagKeys: aws.StringSlice(options.TransitiveTagKeys),
+	}
+	if externalID != "" {
+		input.ExternalId = aws.String(externalID)
+	}
+	if options.DurationSeconds != 0 {
+		input.DurationSeconds = aws.Int64(options.DurationSeconds)
+	}
+	if options.Policy != "" {
+		input.Policy = aws.String(options.Policy)
+	}
+
+	tagKeys := make([]string, 0, len(options.Tags))
+	for key := range options.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		input.Tags = append(input.Tags, &sts.Tag{Key: aws.String(key), Value: aws.String(options.Tags[key])})
+	}
+
+	result, err := svc.AssumeRole(input)
 	if err != nil {
 		return "", "", "", err
 	}
 	return *result.Credentials.AccessKeyId, *result.Credentials.SecretAccessKey, *result.Credentials.SessionToken, err
 }
 
-func assumeRole(svc stsiface.STSAPI, role, externalID string) (access, secret, sessionToken string, err error) {
-	sessionName := strconv.Itoa(rand.Int())
+func assumeRole(svc stsiface.STSAPI, role, externalID string, options assumeRoleOptions) (access, secret, sessionToken string, err error) {
+	if options.SessionName == "" {
+		options.SessionName = strconv.Itoa(rand.Int())
+	}
 
 	// irsa does not work with externalID, only the "traditional" assume role does
 	if externalID == "" {
-		return assumeRoleWithWebIdentity(svc, role, sessionName)
+		return assumeRoleWithWebIdentity(svc, role, options)
 	}
-	return assumeRoleWithTrustedIdentity(svc, role, externalID, sessionName)
+	return assumeRoleWithTrustedIdentity(svc, role, externalID, options)
+}
+
+// awsProcessCredentials is the output of a credential process, the same format the aws CLI expects.
+type awsProcessCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// runCredentialProcess runs the credential process of a connection as the user of the environment and without the
+// environment of the plugin, like the CLI of that user runs it from its config file.
+func runCredentialProcess(env common.Environment, command string) (access, secret, sessionToken string, err error) {
+	log.Debug("running credential process")
+	// only the standard output of the process holds the credentials
+	output, err := common.ExecuteCommand(env, nil, nil, "/bin/sh", "-c", "exec 2>/dev/null; "+command)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "credential process failed")
+	}
+
+	var processCredentials awsProcessCredentials
+	if err = json.Unmarshal(output, &processCredentials); err != nil {
+		return "", "", "", errors.New("credential process returned invalid json")
+	}
+	if processCredentials.Version != 1 {
+		return "", "", "", errors.Errorf("unsupported credential process version %d", processCredentials.Version)
+	}
+	if processCredentials.AccessKeyId == "" || processCredentials.SecretAccessKey == "" {
+		return "", "", "", errors.New("credential process returned no credentials")
+	}
+	return processCredentials.AccessKeyId, processCredentials.SecretAccessKey, processCredentials.SessionToken, nil
+}
+
+// resolveAwsProcessCreds replaces the credential process of a connection with the credentials it returns when run
+// as the user of the environment, for sdk clients which can't run it themselves. Other connections are returned as is.
+func resolveAwsProcessCreds(env common.Environment, credentials map[string]string) (map[string]string, error) {
+	if credentials[credentialProcess] == "" {
+		return credentials, nil
+	}
+
+	resolved := make(map[string]string, len(credentials))
+	for key, value := range credentials {
+		if key != credentialProcess {
+			resolved[key] = value
+		}
+	}
+
+	var err error
+	resolved[awsAccessKeyId], resolved[awsSecretAccessKey], resolved[awsSessionToken], err = runCredentialProcess(env, credentials[credentialProcess])
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to run the credential process")
+	}
+	return resolved, nil
+}
+
+// newAwsSession creates an sdk session out of credentials which were already resolved by resolveAwsCreds
+func newAwsSession(credentials map[string]string, region string) (*session.Session, error) {
+	return session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: awsCredentials.NewStaticCredentials(credentials[awsAccessKeyId], credentials[awsSecretAccessKey], credentials[awsSessionToken]),
+	})
 }
@@ -3,11 +3,14 @@ package implementation
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -16,7 +19,7 @@ type STSMockClient struct {
 }
 
 func (s *STSMockClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
-	if *input.ExternalId == "eligezer" {
+	if aws.StringValue(input.ExternalId) == "eligezer" {
 		return &sts.AssumeRoleOutput{}, fmt.Errorf("invalid external ID")
 	}
 	return &sts.AssumeRoleOutput{
@@ -87,7 +90,7 @@ func TestAssumeRole(t *testing.T) {
 	client := &STSMockClient{}
 	for _, tt := range tests {
 		t.Run("test assumeRole(): "+tt.name, func(t *testing.T) {
-			_, _, _, err := assumeRole(client, tt.args.role, tt.args.externalID)
+			_, _, _, err := assumeRole(client, tt.args.role, tt.args.externalID, assumeRoleOptions{})
 			if tt.wantErr != "" {
 				require.NotNil(t, err, tt.name)
 				assert.Contains(t, err.Error(), tt.wantErr, tt.name)
@@ -117,6 +120,11 @@ func TestDetectConnectionType(t *testing.T) {
 			args:           args{awsCreds: map[string]string{awsAccessKeyId: "", awsSecretAccessKey: "", roleArn: "arn:aws:iam::12345678910:role/test", externalID: "eligezer"}},
 			credsType:      "roleBased",
 		},
+		{
+			name:           "credential process",
+			args:           args{awsCreds: map[string]string{awsAccessKeyId: "ASDHASDSDHADHASD", awsSecretAccessKey: "ASDSADASDASAS", credentialProcess: "/usr/local/bin/creds"}},
+			credsType:      "processBased",
+		},
 		{
 			name:           "bad credentials",
 			args:           args{awsCreds: map[string]string{awsAccessKeyId: "", awsSecretAccessKey: "", roleArn: "", externalID: ""}},
@@ -129,4 +137,206 @@ func TestDetectConnectionType(t *testing.T) {
 			assert.Equal(t, tt.credsType, result)
 		})
 	}
-}
\ No newline at end of file
+}
+
+type STSRecordingClient struct {
+	STSMockClient
+	assumeRoleInput *sts.AssumeRoleInput
+	webIdentityInput *sts.AssumeRoleWithWebIdentityInput
+}
+
+func (s *STSRecordingClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	s.assumeRoleInput = input
+	return s.STSMockClient.AssumeRole(input)
+}
+
+func (s *STSRecordingClient) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	s.webIdentityInput = input
+	return s.STSMockClient.AssumeRoleWithWebIdentity(input)
+}
+
+func TestAssumeRoleOptions(t *testing.T) {
+	options := assumeRoleOptions{
+		SessionName: "exec-1234",
+		DurationSeconds: 7200,
+		Tags: map[string]string{"team": "sre", "execution": "exec-1234"},
+		TransitiveTagKeys: []string{"team"},
+		Policy: `{"Version":"2012-10-17","Statement":[]}`,
+	}
+
+	client := &STSRecordingClient{}
+	_, _, _, err := assumeRole(client, "arn:aws:iam::12345678910:role/good-role", "waaaa", options)
+	require.Nil(t, err)
+	assert.Equal(t, &sts.AssumeRoleInput{
+		RoleArn: aws.String("arn:aws:iam::12345678910:role/good-role"),
+		RoleSessionName: aws.String("exec-1234"),
+		ExternalId: aws.String("waaaa"),
+		DurationSeconds: aws.Int64(7200),
+		Policy: aws.String(`{"Version":"2012-10-17","Statement":[]}`),
+		Tags: []*sts.Tag{
+			{Key: aws.String("execution"), Value: aws.String("exec-1234")},
+			{Key: aws.String("team"), Value: aws.String("sre")},
+		},
+		TransitiveTagKeys: aws.StringSlice([]string{"team"}),
+	}, client.assumeRoleInput)
+
+	file := "/tmp/lewl1234"
+	require.Nil(t, os.WriteFile(file, []byte("token"), 0644))
+	defer os.Remove(file)
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", file)
+
+	_, _, _, err = assumeRole(client, "arn:aws:iam::12345678910:role/good-role", "", assumeRoleOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(3600), *client.webIdentityInput.DurationSeconds)
+	assert.NotEmpty(t, *client.webIdentityInput.RoleSessionName)
+	assert.Nil(t, client.webIdentityInput.Policy)
+}
+
+func TestSanitizeRoleSessionName(t *testing.T) {
+	assert.Equal(t, "exec_1234-abcd", sanitizeRoleSessionName("exec_1234-abcd"))
+	assert.Equal(t, "exec-1234-abcd", sanitizeRoleSessionName("exec/1234 abcd"))
+	assert.Equal(t, 64, len(sanitizeRoleSessionName(strings.Repeat("a", 100))))
+	assert.Equal(t, "", sanitizeRoleSessionName("a"))
+}
+
+type OrganizationsMockClient struct {
+	organizationsiface.OrganizationsAPI
+}
+
+func (c *OrganizationsMockClient) ListAccountsPages(input *organizations.ListAccountsInput, fn func(*organizations.ListAccountsOutput, bool) bool) error {
+	pages := []*organizations.ListAccountsOutput{
+		{Accounts: []*organizations.Account{
+			{Id: aws.String("222222222222"), Status: aws.String(organizations.AccountStatusActive)},
+			{Id: aws.String("333333333333"), Status: aws.String(organizations.AccountStatusSuspended)},
+		}},
+		{Accounts: []*organizations.Account{
+			{Id: aws.String("111111111111"), Status: aws.String(organizations.AccountStatusActive)},
+		}},
+	}
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestResolveFanOutAccounts(t *testing.T) {
+	client := func() organizationsiface.OrganizationsAPI { return &OrganizationsMockClient{} }
+
+	accounts, err := resolveFanOutAccounts("111111111111, 222222222222,111111111111", client)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"111111111111", "222222222222"}, accounts)
+
+	accounts, err = resolveFanOutAccounts("organization", client)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"111111111111", "222222222222"}, accounts)
+
+	_, err = resolveFanOutAccounts("1234", client)
+	assert.EqualError(t, err, "invalid aws account id: 1234")
+}
+
+type STSAccountsMockClient struct {
+	stsiface.STSAPI
+}
+
+func (s *STSAccountsMockClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	if input.ExternalId != nil {
+		return nil, fmt.Errorf("unexpected external ID")
+	}
+	if *input.RoleArn == "arn:aws:iam::222222222222:role/audit" {
+		return nil, fmt.Errorf("access denied")
+	}
+	return &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId: aws.String("ACCESS-" + *input.RoleArn),
+			SecretAccessKey: aws.String("SECRET"),
+			SessionToken: aws.String("TOKEN"),
+		},
+	}, nil
+}
+
+func TestAssumeAccountRoles(t *testing.T) {
+	accountCredentials, failed := assumeAccountRoles(&STSAccountsMockClient{}, []string{"111111111111", "222222222222"}, accountRoleArnPrefix("us-east-1"), "audit", assumeRoleOptions{SessionName: "exec-1234"}, 2)
+
+	assert.Equal(t, map[string]map[string]string{
+		"111111111111": {
+			awsAccessKeyId: "ACCESS-arn:aws:iam::111111111111:role/audit",
+			awsSecretAccessKey: "SECRET",
+			awsSessionToken: "TOKEN",
+		},
+	}, accountCredentials)
+	require.Contains(t, failed, "222222222222")
+	assert.Contains(t, failed["222222222222"].Error, "access denied")
+
+	assert.Equal(t, "arn:aws-us-gov:iam::", accountRoleArnPrefix("us-gov-west-1"))
+}
+
+
+type currentUserEnvironment struct {
+	home string
+}
+
+func (c currentUserEnvironment) GetHomeDirectory() string { return c.home }
+func (c currentUserEnvironment) GetExecutorUid() uint32    { return uint32(os.Getuid()) }
+func (c currentUserEnvironment) GetExecutorGid() uint32    { return uint32(os.Getgid()) }
+
+func TestResolveAwsProcessCreds(t *testing.T) {
+	env := currentUserEnvironment{home: t.TempDir()}
+
+	credentials := map[string]string{credentialProcess: `echo noise >&2; echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "SECRET", "SessionToken": "TOKEN"}'`, awsRegion: "eu-west-1"}
+	resolved, err := resolveAwsProcessCreds(env, credentials)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{awsAccessKeyId: "AKID", awsSecretAccessKey: "SECRET", awsSessionToken: "TOKEN", awsRegion: "eu-west-1"}, resolved)
+	assert.NotEmpty(t, credentials[credentialProcess], "the connection itself isn't changed")
+
+	// the process doesn't inherit the environment of the plugin
+	require.NoError(t, os.Setenv("BLINK_TEST_PLUGIN_SECRET", "leaked"))
+	defer os.Unsetenv("BLINK_TEST_PLUGIN_SECRET")
+	_, err = resolveAwsProcessCreds(env, map[string]string{credentialProcess: `test -z "$BLINK_TEST_PLUGIN_SECRET" && echo '{"Version": 1, "AccessKeyId": "AKID", "SecretAccessKey": "SECRET"}'`})
+	assert.NoError(t, err)
+
+	for _, process := range []string{"exit 1", "echo not json", `echo '{"Version": 2, "AccessKeyId": "AKID", "SecretAccessKey": "SECRET"}'`, `echo '{"Version": 1}'`} {
+		_, err = resolveAwsProcessCreds(env, map[string]string{credentialProcess: process})
+		assert.Error(t, err, process)
+	}
+
+	userBased := map[string]string{awsAccessKeyId: "AKID", awsSecretAccessKey: "SECRET"}
+	resolved, err = resolveAwsProcessCreds(env, userBased)
+	require.NoError(t, err)
+	assert.Equal(t, userBased, resolved)
+}
+
+func TestCheckSessionRestrictions(t *testing.T) {
+	profiles := map[string]awsProfile{"prod": {RoleArn: "arn:aws:iam::123456789012:role/deploy"}}
+
+	tests := []struct {
+		name        string
+		options     assumeRoleOptions
+		assumesRole bool
+		appliesTags bool
+		profiles    map[string]awsProfile
+		wantErr     string
+	}{
+		{name: "no restrictions without a role", profiles: profiles},
+		{name: "policy with a role", options: assumeRoleOptions{Policy: `{"Version": "2012-10-17"}`}, assumesRole: true},
+		{name: "policy without a role", options: assumeRoleOptions{Policy: `{"Version": "2012-10-17"}`}, wantErr: "these credentials assume no role"},
+		{name: "tags without a role", options: assumeRoleOptions{Tags: map[string]string{"team": "web"}}, wantErr: "these credentials assume no role"},
+		{name: "tags with an external id", options: assumeRoleOptions{Tags: map[string]string{"team": "web"}}, assumesRole: true, appliesTags: true},
+		{name: "tags with a web identity", options: assumeRoleOptions{Tags: map[string]string{"team": "web"}}, assumesRole: true, wantErr: "require a connection with an external_id"},
+		{name: "transitive tag keys with a web identity", options: assumeRoleOptions{TransitiveTagKeys: []string{"team"}}, assumesRole: true, wantErr: "require a connection with an external_id"},
+		{name: "policy with profiles", options: assumeRoleOptions{Policy: `{"Version": "2012-10-17"}`}, assumesRole: true, profiles: profiles, wantErr: "can't be combined with profiles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSessionRestrictions(tt.options, tt.assumesRole, tt.appliesTags, tt.profiles)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package implementation
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	azureAppId             = "app_id"
+	azureClientSecret      = "client_secret"
+	azureTenantId          = "tenant_id"
+	azureClientCertificate = "client_certificate"
+	azureFederatedToken    = "federated_token"
+	azureManagedIdentity   = "managed_identity"
+	azureAuthMethod        = "auth_method"
+	azureSubscription      = "subscription"
+
+	azureAuthMethodSecret          = "secret"
+	azureAuthMethodCertificate     = "certificate"
+	azureAuthMethodFederated       = "federated"
+	azureAuthMethodManagedIdentity = "managed_identity"
+
+	subscriptionParameterName = "Subscription"
+)
+
+// azureLogin holds the arguments of `az login`. Secrets are handed to the CLI as @file arguments, which the az CLI
+// expands itself, so they neither show up in the process list nor break on special characters. They're removed
+// after login, while Files (the client certificate) stay since the CLI reads them again to refresh its tokens.
+type azureLogin struct {
+	Args    []string
+	Secrets map[string]string
+	Files   map[string]string
+}
+
+func executeCoreAzureAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	credentials, err := ctx.GetCredentials("azure")
+	if err != nil {
+		return nil, err
+	}
+
+	// we currently don't support oauth. if there's an oauth token return err
+	if _, ok := credentials["Token"]; ok {
+		return nil, errors.New("az CLI currently does not support OAuth connections.")
+	}
+
+	command, ok := request.Parameters[commandParameterName]
+	if !ok {
+		return nil, errors.New("command to Azure CLI wasn't provided")
+	}
+
+	cliUser, err := e.CreateCliUser("az")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cli user")
+	}
+	defer e.CleanupCliUser(cliUser.Username)
+	ce := e.CreateCliUserPee(cliUser)
+
+	login, err := buildAzureLogin(credentials, ce.GetHomeDirectory())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, files := range []map[string]string{login.Secrets, login.Files} {
+		for name, content := range files {
+			if err = ce.WriteToFile(name, []byte(content), 0600); err != nil {
+				return nil, errors.Wrap(err, "failed writing azure login secret")
+			}
+		}
+	}
+
+	output, err := common.ExecuteCommand(ce, request, []string{}, common.ClisDir+"/az", login.Args...)
+	for name := range login.Secrets {
+		_ = os.Remove(name)
+	}
+	if err != nil {
+		return common.GetCommandFailureResponse(output, err, false)
+	}
+
+	subscription := request.Parameters[subscriptionParameterName]
+	if subscription == "" {
+		subscription = credentials[azureSubscription]
+	}
+	if subscription != "" {
+		if output, err := common.ExecuteCommand(ce, request, []string{}, common.ClisDir+"/az", "account", "set", "--subscription", subscription); err != nil {
+			return common.GetCommandFailureResponse(output, err, false)
+		}
+	}
+
+	azureUsernameEnv := fmt.Sprintf("AZURE_USER=%s", cliUser.Username)
+	output, err = common.ExecuteCommand(e, request, []string{azureUsernameEnv}, "/bin/bash", "-c", command)
+	if err != nil {
+		return common.GetCommandFailureResponse(output, err, true)
+	}
+
+	return output, nil
+}
+
+// buildAzureLogin returns the `az login` arguments for the auth method of the connection. Without an explicit
+// auth_method it's detected from the fields of the connection.
+func buildAzureLogin(credentials map[string]string, secretsDir string) (*azureLogin, error) {
+	method := credentials[azureAuthMethod]
+	if method == "" {
+		managedIdentity, _ := strconv.ParseBool(credentials[azureManagedIdentity])
+		switch {
+		case managedIdentity:
+			method = azureAuthMethodManagedIdentity
+		case credentials[azureClientCertificate] != "":
+			method = azureAuthMethodCertificate
+		case credentials[azureFederatedToken] != "":
+			method = azureAuthMethodFederated
+		default:
+			method = azureAuthMethodSecret
+		}
+	}
+
+	login := &azureLogin{Secrets: map[string]string{}, Files: map[string]string{}}
+	secretFile := func(name string, value string) string {
+		secretPath := path.Join(secretsDir, name)
+		login.Secrets[secretPath] = value
+		return secretPath
+	}
+
+	if method == azureAuthMethodManagedIdentity {
+		login.Args = []string{"login", "--identity"}
+		// a user assigned identity is selected by its client id
+		if appId := credentials[azureAppId]; appId != "" {
+			login.Args = append(login.Args, "--username", appId)
+		}
+		return login, nil
+	}
+
+	appId, tenantId := credentials[azureAppId], credentials[azureTenantId]
+	if appId == "" || tenantId == "" {
+		return nil, errors.New("connection to Azure is invalid")
+	}
+
+	login.Args = []string{"login", "--service-principal", "--username", appId, "--tenant", tenantId}
+	switch method {
+	case azureAuthMethodSecret:
+		if credentials[azureClientSecret] == "" {
+			return nil, errors.New("connection to Azure is invalid")
+		}
+		login.Args = append(login.Args, "--password", "@"+secretFile("azure-client-secret", credentials[azureClientSecret]))
+	case azureAuthMethodCertificate:
+		if credentials[azureClientCertificate] == "" {
+			return nil, errors.New("azure certificate login requires a client certificate")
+		}
+		// the certificate file holds both the certificate and its private key, it's passed as a path
+		certificatePath := path.Join(secretsDir, "azure-client-certificate.pem")
+		login.Files[certificatePath] = credentials[azureClientCertificate]
+		login.Args = append(login.Args, "--password", certificatePath)
+	case azureAuthMethodFederated:
+		if credentials[azureFederatedToken] == "" {
+			return nil, errors.New("azure federated login requires a federated token")
+		}
+		login.Args = append(login.Args, "--federated-token", "@"+secretFile("azure-federated-token", credentials[azureFederatedToken]))
+	default:
+		return nil, errors.Errorf("unsupported azure auth method: %s", method)
+	}
+
+	return login, nil
+}
@@ -0,0 +1,78 @@
+package implementation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAzureLogin(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		expected    *azureLogin
+		wantErr     string
+	}{
+		{
+			name:        "client secret with spaces",
+			credentials: map[string]string{azureAppId: "app", azureTenantId: "tenant", azureClientSecret: "a secret with spaces"},
+			expected: &azureLogin{
+				Args:    []string{"login", "--service-principal", "--username", "app", "--tenant", "tenant", "--password", "@/home/az/azure-client-secret"},
+				Secrets: map[string]string{"/home/az/azure-client-secret": "a secret with spaces"},
+				Files:   map[string]string{},
+			},
+		},
+		{
+			name:        "certificate",
+			credentials: map[string]string{azureAppId: "app", azureTenantId: "tenant", azureClientCertificate: "-----BEGIN CERTIFICATE-----"},
+			expected: &azureLogin{
+				Args:    []string{"login", "--service-principal", "--username", "app", "--tenant", "tenant", "--password", "/home/az/azure-client-certificate.pem"},
+				Secrets: map[string]string{},
+				Files:   map[string]string{"/home/az/azure-client-certificate.pem": "-----BEGIN CERTIFICATE-----"},
+			},
+		},
+		{
+			name:        "federated token",
+			credentials: map[string]string{azureAuthMethod: "federated", azureAppId: "app", azureTenantId: "tenant", azureFederatedToken: "eyJ"},
+			expected: &azureLogin{
+				Args:    []string{"login", "--service-principal", "--username", "app", "--tenant", "tenant", "--federated-token", "@/home/az/azure-federated-token"},
+				Secrets: map[string]string{"/home/az/azure-federated-token": "eyJ"},
+				Files:   map[string]string{},
+			},
+		},
+		{
+			name:        "user assigned managed identity",
+			credentials: map[string]string{azureManagedIdentity: "true", azureAppId: "client-id"},
+			expected: &azureLogin{
+				Args:    []string{"login", "--identity", "--username", "client-id"},
+				Secrets: map[string]string{},
+				Files:   map[string]string{},
+			},
+		},
+		{
+			name:        "missing tenant",
+			credentials: map[string]string{azureAppId: "app", azureClientSecret: "secret"},
+			wantErr:     "connection to Azure is invalid",
+		},
+		{
+			name:        "unknown method",
+			credentials: map[string]string{azureAuthMethod: "device_code", azureAppId: "app", azureTenantId: "tenant"},
+			wantErr:     "unsupported azure auth method: device_code",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, err := buildAzureLogin(tt.credentials, "/home/az")
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, login)
+		})
+	}
+}
@@ -1,26 +1,148 @@
 package implementation
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/blinkops/blink-core/common"
 	"github.com/blinkops/blink-core/implementation/execution"
 	"github.com/blinkops/blink-sdk/plugin"
+	log "github.com/sirupsen/logrus"
 	"os"
+	"regexp"
+	"strings"
 )
 
+const (
+	bashContextPrefix    = "BLINK_CONTEXT_"
+	bashParameterPrefix  = "BLINK_PARAM_"
+	bashOutputFileEnv    = "BLINK_OUTPUT_FILE"
+	maxBashVariableValue = 32 * 1024
+	maxBashOutputFile    = 1024 * 1024
+)
+
+var invalidEnvironmentNameCharacters = regexp.MustCompile("[^A-Z0-9_]")
+
 func executeCoreBashAction(execution *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
 	code, ok := request.Parameters[codeKey]
 	if !ok {
 		return nil, errors.New("no code provided for execution")
 	}
 
-	environmentVariables := os.Environ()
+	// The code can write key=value lines to this file in order to update the execution context.
+	outputFile, err := execution.WriteToTempFile(nil, ".blink-bash-output-")
+	if err != nil {
+		return nil, err
+	}
+	defer func(name string) { _ = os.Remove(name) }(outputFile)
+
+	environmentVariables := bashContextEnvironment(ctx.GetAllContextEntries())
+	environmentVariables = append(environmentVariables, bashParametersEnvironment(request.Parameters)...)
+	environmentVariables = append(environmentVariables, fmt.Sprintf("%s=%s", bashOutputFileEnv, outputFile))
 
 	output, err := common.ExecuteCommand(execution, request, environmentVariables, "/bin/bash", "-c", fmt.Sprintf("%s", code))
 	if err != nil {
 		return common.GetCommandFailureResponse(output, err, true)
 	}
 
+	// the code can replace the file, e.g. with a symlink, so it's read as the session user
+	outputFileContent, err := common.ReadFileAs(execution, outputFile, maxBashOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if contextUpdates := parseBashOutputFile(outputFileContent); len(contextUpdates) > 0 {
+		updatedContext := ctx.GetAllContextEntries()
+		for key, value := range contextUpdates {
+			updatedContext[key] = value
+		}
+		ctx.ReplaceContext(updatedContext)
+	}
+
 	return output, nil
 }
+
+// bashContextEnvironment exposes the context entries as BLINK_CONTEXT_<KEY> variables, values which
+// aren't strings are passed as json.
+func bashContextEnvironment(contextEntries map[string]interface{}) []string {
+	var environment []string
+	for key, value := range contextEntries {
+		stringValue, ok := value.(string)
+		if !ok {
+			marshaledValue, err := json.Marshal(value)
+			if err != nil {
+				log.Warnf("skipping context entry %s for bash: %v", key, err)
+				continue
+			}
+			stringValue = string(marshaledValue)
+		}
+
+		if variable, ok := bashEnvironmentVariable(bashContextPrefix, key, stringValue); ok {
+			environment = append(environment, variable)
+		}
+	}
+	return environment
+}
+
+// bashParametersEnvironment exposes the request parameters, except for the code itself, as BLINK_PARAM_<NAME> variables.
+func bashParametersEnvironment(parameters map[string]string) []string {
+	var environment []string
+	for name, value := range parameters {
+		if name == codeKey {
+			continue
+		}
+
+		if variable, ok := bashEnvironmentVariable(bashParameterPrefix, name, value); ok {
+			environment = append(environment, variable)
+		}
+	}
+	return environment
+}
+
+func bashEnvironmentVariable(prefix string, key string, value string) (string, bool) {
+	if len(value) > maxBashVariableValue {
+		log.Warnf("skipping %s for bash, value is larger than %d bytes", key, maxBashVariableValue)
+		return "", false
+	}
+
+	name := prefix + invalidEnvironmentNameCharacters.ReplaceAllString(strings.ToUpper(key), "_")
+	return fmt.Sprintf("%s=%s", name, strings.ReplaceAll(value, "\x00", "")), true
+}
+
+// parseBashOutputFile reads the key=value lines written by the code, empty lines and comments are ignored.
+// Keys are trimmed, values are kept as written.
+func parseBashOutputFile(content []byte) map[string]string {
+	updates := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBashVariableValue*4)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if trimmedLine := strings.TrimSpace(line); trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+
+		key, value, found := cutString(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			log.Warnf("ignoring invalid bash output line: %s", line)
+			continue
+		}
+		updates[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Warnf("failed reading bash output file: %v", err)
+	}
+
+	return updates
+}
+
+func cutString(s string, separator string) (before string, after string, found bool) {
+	if i := strings.Index(s, separator); i >= 0 {
+		return s[:i], s[i+len(separator):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,126 @@
+package implementation
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBashParametersEnvironment(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   []string
+	}{
+		{
+			name:       "code is not exported",
+			parameters: map[string]string{codeKey: "echo hi", "Region": "us-east-1"},
+			expected:   []string{"BLINK_PARAM_REGION=us-east-1"},
+		},
+		{
+			name:       "names are sanitized",
+			parameters: map[string]string{"Base Branch": "main", "max-log.size": "10"},
+			expected:   []string{"BLINK_PARAM_BASE_BRANCH=main", "BLINK_PARAM_MAX_LOG_SIZE=10"},
+		},
+		{
+			name:       "null bytes are dropped",
+			parameters: map[string]string{"value": "a\x00b"},
+			expected:   []string{"BLINK_PARAM_VALUE=ab"},
+		},
+		{
+			name:       "large values are skipped",
+			parameters: map[string]string{"small": "x", "large": strings.Repeat("x", maxBashVariableValue+1)},
+			expected:   []string{"BLINK_PARAM_SMALL=x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test bashParametersEnvironment(): "+tt.name, func(t *testing.T) {
+			environment := bashParametersEnvironment(tt.parameters)
+			sort.Strings(environment)
+			assert.Equal(t, tt.expected, environment)
+		})
+	}
+}
+
+func TestBashContextEnvironment(t *testing.T) {
+	environment := bashContextEnvironment(map[string]interface{}{
+		"name":     "web",
+		"replicas": 3,
+		"labels":   map[string]string{"app": "web"},
+	})
+	sort.Strings(environment)
+
+	assert.Equal(t, []string{
+		`BLINK_CONTEXT_LABELS={"app":"web"}`,
+		"BLINK_CONTEXT_NAME=web",
+		"BLINK_CONTEXT_REPLICAS=3",
+	}, environment)
+}
+
+func TestParseBashOutputFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string]string
+	}{
+		{
+			name:     "key value lines",
+			content:  "status=ok\ncount=3\n",
+			expected: map[string]string{"status": "ok", "count": "3"},
+		},
+		{
+			name:     "values keep their equal signs",
+			content:  "query=a=b\n",
+			expected: map[string]string{"query": "a=b"},
+		},
+		{
+			name:     "comments and empty lines",
+			content:  "# comment\n\n   \nstatus=ok",
+			expected: map[string]string{"status": "ok"},
+		},
+		{
+			name:     "malformed lines are ignored",
+			content:  "no separator\n=missing key\n  =\nstatus=ok\n",
+			expected: map[string]string{"status": "ok"},
+		},
+		{
+			name:     "keys are trimmed and the last value wins",
+			content:  " status =first\nstatus=second\n",
+			expected: map[string]string{"status": "second"},
+		},
+		{
+			name:     "values keep their whitespace",
+			content:  "indented=  two spaces\ntrailing=tab\t\n",
+			expected: map[string]string{"indented": "  two spaces", "trailing": "tab\t"},
+		},
+		{
+			name:     "empty value",
+			content:  "status=\n",
+			expected: map[string]string{"status": ""},
+		},
+		{
+			name:     "lines within the scanner limit",
+			content:  "large=" + strings.Repeat("x", maxBashVariableValue*2) + "\n",
+			expected: map[string]string{"large": strings.Repeat("x", maxBashVariableValue*2)},
+		},
+		{
+			name:     "reading stops at a line over the scanner limit",
+			content:  "status=ok\nlarge=" + strings.Repeat("x", maxBashVariableValue*4) + "\nafter=ignored\n",
+			expected: map[string]string{"status": "ok"},
+		},
+		{
+			name:     "empty file",
+			content:  "",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test parseBashOutputFile(): "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseBashOutputFile([]byte(tt.content)))
+		})
+	}
+}
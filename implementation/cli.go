@@ -9,10 +9,9 @@ import (
 	"github.com/blinkops/blink-core/implementation/execution"
 	log "github.com/sirupsen/logrus"
 	"net/url"
-	"os"
+	"os/user"
 	"path"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/blinkops/blink-core/common"
@@ -36,6 +35,9 @@ const (
 )
 
 func executeAwsCli(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	if err := enforcePolicies(request, request.Parameters[commandParameterName], common.UnknownDeletions); err != nil {
+		return nil, err
+	}
 	return executeCoreAWSAction(e, ctx, request, "aws")
 }
 
@@ -55,21 +57,56 @@ func executeCoreAWSAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.
 		return nil, errors.New("command to AWS CLI wasn't provided")
 	}
 
-	credentials, err := ctx.GetCredentials("aws")
+	profiles, err := getAwsProfiles(request)
+	if err != nil {
+		return nil, err
+	}
+
 	// if no credentials provided, execute without credentials, otherwise resolve assumed role etc.
-	if err == nil {
-		if credentials, err = resolveAwsCreds(credentials, region); err != nil {
-			log.Warnf("failed resolving aws credentials, will try without credentials: %v", err)
+	// a fan-out never falls back to the identity of the plugin, which could list and assume roles it shouldn't
+	fanOut := request.Parameters[regionsParameterName] != "" || request.Parameters[accountsParameterName] != ""
+	credentials, err := ctx.GetCredentials("aws")
+	if err != nil {
+		if fanOut {
+			return nil, errors.New("fanning out requires an aws connection")
 		}
+		credentials = nil
+	}
+	// the account fan-out assumes a role in every account with the options of the step
+	credentials, err = resolveAwsConnection(ctx, request, credentials, region, profiles, request.Parameters[accountsParameterName] != "", !fanOut)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed resolving aws credentials")
 	}
 
-	cliUsername, err := initAwsEnv(e, cliCommand, credentials, region)
+	cliUsername, err := initAwsEnv(e, cliCommand, credentials, region, profiles)
 	defer e.CleanupCliUser(cliUsername)
 
 	if err != nil {
 		return nil, err
 	}
 	awsUsernameEnv := fmt.Sprintf("%s_USER=%s", strings.ToUpper(cliCommand), cliUsername)
+	var ce *execution.PrivateExecutionEnvironment
+	if fanOut {
+		// the fan-out lists its targets with the sdk, which can't run a credential process itself
+		cliUser, err := user.Lookup(cliUsername)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed looking up the cli user")
+		}
+		ce = e.CreateCliUserPee(cliUser)
+		if credentials, err = resolveAwsProcessCreds(ce, credentials); err != nil {
+			return nil, err
+		}
+	}
+	if request.Parameters[accountsParameterName] != "" {
+		if request.Parameters[regionsParameterName] != "" {
+			return nil, errors.New("accounts and regions can't be fanned out together")
+		}
+		return executeAwsAccountFanOut(e, ce, ctx, request, []string{awsUsernameEnv}, credentials, region, profiles, command)
+	}
+	if request.Parameters[regionsParameterName] != "" {
+		return executeAwsRegionFanOut(e, request, []string{awsUsernameEnv}, credentials, region, command)
+	}
+
 	output, err := common.ExecuteCommand(e, request, []string{awsUsernameEnv}, "/bin/bash", "-c", command)
 	if err != nil {
 		if bytes.HasPrefix(bytes.TrimSpace(output), []byte("Unable to locate credentials")) {
@@ -81,35 +118,53 @@ func executeCoreAWSAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.
 	return output, nil
 }
 
-func initAwsEnv(e *execution.PrivateExecutionEnvironment, cliCommand string, m map[string]string, region string) (string, error) {
+func initAwsEnv(e *execution.PrivateExecutionEnvironment, cliCommand string, m map[string]string, region string, profiles map[string]awsProfile) (string, error) {
 	user, err := e.CreateCliUser(cliCommand)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create cli user")
 	}
-	cliUserPee := e.CreateCliUserPee(user)
+	return user.Username, writeAwsFiles(e.CreateCliUserPee(user), m, region, profiles, nil)
+}
 
+// writeAwsFiles writes the aws credentials and config files of a CLI user. The default profile holds the connection,
+// the named profiles of the step are chained on top of it and the accounts of a fan-out get a profile each.
+func writeAwsFiles(cliUserPee *execution.PrivateExecutionEnvironment, m map[string]string, region string, profiles map[string]awsProfile, accountCredentials map[string]map[string]string) error {
 	var lines []string
 	lines = append(lines, "[default]")
 	for key, value := range m {
+		// a credential process is run by the CLI itself from the config file, so it can refresh the credentials,
+		// and the session settings of the connection aren't credentials
+		if !isAwsKeyField(key) || m[credentialProcess] != "" {
+			continue
+		}
 		if value != "" {
 			lines = append(lines, fmt.Sprintf("%s = %v", key, value))
 		}
 	}
 	lines = append(lines, fmt.Sprintf("%s = %v\n", "region", region))
 	awsCredFileContent := strings.Join(lines, "\n")
+	accountCredentialsContent, accountConfigContent := buildAwsAccountProfiles(accountCredentials, region)
 
-	if err = cliUserPee.CreateDirectory(path.Join(cliUserPee.GetHomeDirectory(), ".aws")); err != nil {
-		return user.Username, errors.Wrap(err, "failed to create .aws directory")
+	if err := cliUserPee.CreateDirectory(path.Join(cliUserPee.GetHomeDirectory(), ".aws")); err != nil {
+		return errors.Wrap(err, "failed to create .aws directory")
 	}
 
-	if err = cliUserPee.WriteToFile(path.Join(cliUserPee.GetHomeDirectory(), ".aws", "credentials"), []byte(awsCredFileContent), 0600); err != nil {
-		return user.Username, errors.Wrap(err, "failed to write to .aws/credentials")
+	if err := cliUserPee.WriteToFile(path.Join(cliUserPee.GetHomeDirectory(), ".aws", "credentials"), []byte(awsCredFileContent+accountCredentialsContent), 0600); err != nil {
+		return errors.Wrap(err, "failed to write to .aws/credentials")
 	}
 
-	return user.Username, nil
+	if err := cliUserPee.WriteToFile(path.Join(cliUserPee.GetHomeDirectory(), ".aws", "config"), []byte(buildAwsConfigFile(m, region, profiles)+accountConfigContent), 0600); err != nil {
+		return errors.Wrap(err, "failed to write to .aws/config")
+	}
+
+	return nil
 }
 
-func resolveAwsCreds(credentials map[string]string, region string) (map[string]string, error) {
+func isAwsKeyField(key string) bool {
+	return key == awsAccessKeyId || key == awsSecretAccessKey || key == awsSessionToken
+}
+
+func resolveAwsCreds(credentials map[string]string, region string, options assumeRoleOptions) (map[string]string, error) {
 	sessionType, k, v := detectConnectionType(credentials)
 	switch sessionType {
 	case "roleBased":
@@ -119,72 +174,29 @@ func resolveAwsCreds(credentials map[string]string, region string) (map[string]s
 
 		svc := sts.New(sess)
 		var err error
-		credentials[awsAccessKeyId], credentials[awsSecretAccessKey], credentials[awsSessionToken], err = assumeRole(svc, k, v)
+		credentials[awsAccessKeyId], credentials[awsSecretAccessKey], credentials[awsSessionToken], err = assumeRole(svc, k, v, options)
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to assume role with error: ")
 		}
 	case "userBased":
 		credentials[awsSessionToken] = ""
+	case "processBased":
+		// the process never runs as the plugin, CLIs run it from their config file and sdk clients
+		// resolve it as a cli user with resolveAwsProcessCreds
 	default:
-		return nil, errors.New("invalid credentials: make sure access+secret key are supplied OR role_arn+external_id")
+		return nil, errors.New("invalid credentials: make sure access+secret key are supplied OR role_arn+external_id OR credential_process")
 	}
 	return credentials, nil
 }
 
-func executeCoreGITAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
-	sshCredentials, _ := ctx.GetCredentials("ssh")
-	basicAuthCredentials, _ := ctx.GetCredentials("github")
-	basicAuthType := "github"
-	if basicAuthCredentials == nil {
-		basicAuthCredentials, _ = ctx.GetCredentials("gitlab")
-		basicAuthType = "gitlab"
-	}
-	var envList []string
-
-	cliUser, err := e.CreateCliUser("git")
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cli user")
-	}
-	defer e.CleanupCliUser(cliUser.Username)
-	cliUserPee := e.CreateCliUserPee(cliUser)
-
-	envList = append(envList, fmt.Sprintf("GIT_USER=%s", cliUser.Username))
-
-	if basicAuthCredentials != nil {
-		if err = initBasicAuthGitCredentials(cliUserPee, basicAuthCredentials, basicAuthType); err != nil {
-			return nil, err
-		}
-	} else if sshCredentials != nil {
-		if err = initSshCredentials(cliUserPee, sshCredentials); err != nil {
-			return nil, err
-		}
-	}
-
-	command, ok := request.Parameters[commandParameterName]
-	if !ok {
-		return nil, errors.New("command to GIT CLI wasn't provided")
-	}
-
-	output, err := common.ExecuteCommand(e, request, envList, "/bin/bash", "-c", command)
-	if err != nil {
-		return common.GetCommandFailureResponse(output, err, true)
-	}
-
-	return output, nil
-}
-
-func initBasicAuthGitCredentials(pee *execution.PrivateExecutionEnvironment, credentials map[string]string, authType string) error {
-	token := credentials["Token"]
-	if token == "" {
-		return errors.Errorf("%s basic-auth connection is missing a token", authType)
-	}
+func initBasicAuthGitCredentials(pee *execution.PrivateExecutionEnvironment, credentials map[string]string, authType string, token string) error {
 	host := extractGitHost(credentials, authType)
 	output, err := common.ExecuteBash(pee, nil, nil, common.ClisDir+"/git config --global credential.helper store")
 	if err != nil {
 		return errors.Wrapf(err, "failed to config git credentials.helper with output [%s]: ", output)
 	}
 
-	gitURL := fmt.Sprintf("https://oauth2:%s@%s\n", token, host)
+	gitURL := fmt.Sprintf("https://%s:%s@%s\n", gitTokenUsername(authType), token, host)
 	if err = pee.WriteToFile(path.Join(pee.GetHomeDirectory(), ".git-credentials"), []byte(gitURL), 0700); err != nil {
 		return errors.Wrap(err, "failed to write to .git-credentials")
 	}
@@ -192,6 +204,15 @@ func initBasicAuthGitCredentials(pee *execution.PrivateExecutionEnvironment, cre
 	return nil
 }
 
+// gitTokenUsername is the user name git authenticates with next to the token, github app installation
+// tokens are only accepted with x-access-token.
+func gitTokenUsername(authType string) string {
+	if authType == "gitlab" {
+		return "oauth2"
+	}
+	return "x-access-token"
+}
+
 func extractGitHost(credentials map[string]string, authType string) interface{} {
 	requestUrl := credentials["REQUEST_URL"]
 	if requestUrl == "" {
@@ -212,90 +233,40 @@ func defaultGitHost(authType string) interface{} {
 	return "github.com"
 }
 
-func initSshCredentials(pee *execution.PrivateExecutionEnvironment, credentials map[string]string) error {
-	if err := pee.CreateDirectory(".ssh"); err != nil {
-		return errors.Wrap(err, "failed creating .ssh directory")
-	}
-
-	sshDir := path.Join(pee.GetHomeDirectory(), ".ssh")
-	if err := os.Chmod(sshDir, 0700); err != nil {
-		return errors.Wrap(err, "failed chmod .ssh directory")
-	}
-
-	key, ok := credentials["key"]
-	if !ok || key == "" {
-		return errors.New("missing ssh key")
-	}
-
-	usr, ok := credentials["username"]
-	if !ok || usr == "" {
-		return errors.New("missing ssh username")
-	}
-
-	passphrase, ok := credentials["passphrase"]
-	if passphrase != "" {
-		return errors.New("core.git does not support ssh connection with passphrase")
-	}
-
-	if err := pee.WriteToFile(path.Join(sshDir, "id_rsa"), []byte(key), 0600); err != nil {
-		return err
-	}
-
-	sshConfigFile := fmt.Sprintf("Host *\n\tUser %s\n\tStrictHostKeyChecking no\n", usr)
-	if err := pee.WriteToFile(path.Join(sshDir, "config"), []byte(sshConfigFile), 0600); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func executeCoreKubernetesAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	if err := enforcePolicies(request, request.Parameters[commandParameterName], common.UnknownDeletions); err != nil {
+		return nil, err
+	}
 	return kubectl(e, ctx, request, nil)
 }
 
 type prepareFunc func(e *execution.PrivateExecutionEnvironment) (string, error)
 
 func kubectl(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, prepFn prepareFunc) ([]byte, error) {
-	credentials, err := ctx.GetCredentials("kubernetes")
-	if err != nil {
-		return nil, err
-	}
-
-	bearerToken, ok := credentials["bearer_token"]
-	if !ok {
-		return nil, errors.New("connection to K8S is invalid")
-	}
-
-	apiServerURL, ok := credentials["kubernetes_api_url"]
-	if !ok {
-		return nil, errors.New("connection to K8S is invalid")
-	}
-
-	verifyCertificate, ok := credentials["verify"]
-	if !ok {
-		return nil, errors.New("connection to K8S is invalid")
-	}
-
-	command, ok := request.Parameters[commandParameterName]
-	if !ok {
-		return nil, errors.New("command to K8S CLI wasn't provided")
-	}
+	return executeKubernetesCli(e, ctx, request, "kubectl", "K8S_USER", prepFn)
+}
 
-	cliUser, err := e.CreateCliUser("kubectl")
+// executeKubernetesCli runs the command of a kubernetes CLI (kubectl, helm) as an isolated CLI user
+// whose kubeconfig is set up from the step's connection.
+func executeKubernetesCli(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, cli string, cliUserEnvName string, prepFn prepareFunc) ([]byte, error) {
+	cliUser, err := e.CreateCliUser(cli)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create cli user")
 	}
 	defer e.CleanupCliUser(cliUser.Username)
 	ce := e.CreateCliUserPee(cliUser)
 
+	credentials, err := resolveKubernetesCredentials(ce, ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
 	pathToKubeConfigDirectory := path.Join(ce.GetHomeDirectory(), ".kube")
 	if err = ce.CreateDirectory(pathToKubeConfigDirectory); err != nil {
 		return nil, errors.Wrap(err, "Failed to create kube config directory")
 	}
 
-	verify, _ := strconv.ParseBool(verifyCertificate)
-
-	if output, err := initKubernetesEnvironment(ce, nil, fmt.Sprintf("%s", bearerToken), fmt.Sprintf("%s", apiServerURL), verify); err != nil {
+	if output, err := initKubernetesEnvironment(ce, nil, credentials); err != nil {
 		return common.GetCommandFailureResponse(output, err, false)
 	}
 
@@ -307,56 +278,13 @@ func kubectl(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext
 		request.Parameters[commandParameterName] = realCmd
 	}
 
-	k8sUserEnv := fmt.Sprintf("K8S_USER=%s", cliUser.Username)
-	output, err := common.ExecuteBash(e, request, []string{k8sUserEnv}, command)
-	if err != nil {
-		return common.GetCommandFailureResponse(output, err, true)
-	}
-
-	return output, nil
-}
-
-func executeCoreVaultAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
-	credentials, err := ctx.GetCredentials("vault")
-	if err != nil {
-		return nil, err
-	}
-
-	token, ok := credentials[vaultToken]
-	if !ok {
-		return nil, errors.New("connection to vault is invalid")
-	}
-
-	apiServerURL, ok := credentials[vaultAddress]
-	if !ok {
-		return nil, errors.New("connection to vault is invalid")
-	}
-
 	command, ok := request.Parameters[commandParameterName]
 	if !ok {
-		return nil, errors.New("command to vault wasn't provided")
-	}
-
-	environment := []string{
-		fmt.Sprintf("%s=%s", vaultAddress, apiServerURL),
-		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
-	}
-	cliUser, err := e.CreateCliUser("vault")
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cli user")
-	}
-	defer e.CleanupCliUser(cliUser.Username)
-	ce := e.CreateCliUserPee(cliUser)
-	// RUN vault login to connect to the vault at the address provided by the user in the connection.
-	if output, err := common.ExecuteCommand(ce, nil, environment, common.ClisDir+"/vault", "login", token); err != nil {
-		return common.GetCommandFailureResponse(output, err, false)
+		return nil, errors.New("command to K8S CLI wasn't provided")
 	}
 
-	environment = append(environment, fmt.Sprintf("VAULT_USER=%s", cliUser.Username))
-	log.Infof("VAULT env: %s", strings.Join(environment, " ; "))
-
-	// execute the user command
-	output, err := common.ExecuteBash(e, request, environment, command)
+	k8sUserEnv := fmt.Sprintf("%s=%s", cliUserEnvName, cliUser.Username)
+	output, err := common.ExecuteBash(e, request, []string{k8sUserEnv}, command)
 	if err != nil {
 		return common.GetCommandFailureResponse(output, err, true)
 	}
@@ -366,11 +294,23 @@ func executeCoreVaultAction(e *execution.PrivateExecutionEnvironment, ctx *plugi
 
 func executeCoreTerraFormAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
 
+	switch mode := request.Parameters[terraformModeParameterName]; mode {
+	case "", terraformModeCommand:
+	case terraformModePlan, terraformModeApply:
+		return executeCoreTerraFormStructuredAction(e, ctx, request, mode)
+	default:
+		return nil, errors.Errorf("unsupported terraform mode: %s", mode)
+	}
+
 	command, ok := request.Parameters[commandParameterName]
 	if !ok {
 		return nil, errors.New("command to terraform wasn't provided")
 	}
 
+	if err := enforcePolicies(request, command, common.UnknownDeletions); err != nil {
+		return nil, err
+	}
+
 	// Validate the command to check that it doesn't require input, since it can't be supplied through the cli
 	output, err := validateTerraFormCommand(command)
 	if err != nil {
@@ -385,10 +325,9 @@ func executeCoreTerraFormAction(e *execution.PrivateExecutionEnvironment, ctx *p
 			return nil, errors.New("terraform commands must start with \"terraform\" prefix")
 		}
 
-		response, err := common.GetCommandFailureResponse(output, err, true)
-		return fixTerraFormOutput(response), err
+		return common.GetCommandFailureResponse(formatTerraformFailure(output), err, true)
 	}
-	return fixTerraFormOutput(output), nil
+	return formatTerraformOutput(output), nil
 }
 
 func runTerraformCommand(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
@@ -433,128 +372,7 @@ func runTerraformCommand(e *execution.PrivateExecutionEnvironment, ctx *plugin.A
 	return common.ExecuteBash(e, request, []string{terraformUsernameEnv}, command)
 }
 
-func executeCoreKubernetesApplyAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
-	applyFileContents, ok := request.Parameters[fileParameterName]
-	if !ok {
-		return nil, errors.New("the file is missing from action parameters")
-	}
-
-	if len(applyFileContents) == 0 {
-		return nil, errors.New("can't run apply action with empty file")
-	}
-
-	return kubectl(e, ctx, request, func(ce *execution.PrivateExecutionEnvironment) (string, error) {
-		tempPath := path.Join(ce.GetHomeDirectory(), "kubectl-apply")
-		err := ce.WriteFile([]byte(applyFileContents), tempPath)
-		if err != nil {
-			return "", errors.Wrap(err, "failed creating the apply file")
-		}
-		return fmt.Sprintf("kubectl apply -f %s", tempPath), nil
-	})
-}
-
-func executeCoreGoogleCloudAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
-	credentials, err := ctx.GetCredentials("gcp")
-	if err != nil {
-		return nil, err
-	}
-
-	// we currently don't support oauth. if there's an oauth token return err
-	if _, ok := credentials["Token"]; ok {
-		return nil, errors.New("gcloud CLI currently does not support OAuth connections.")
-	}
-
-	gcpCredentials, ok := credentials["credentials"]
-	if !ok {
-		return nil, errors.New("connection to GCP is invalid")
-	}
-
-	command, ok := request.Parameters[commandParameterName]
-	if !ok {
-		return nil, errors.New("command to Google Cloud CLI wasn't provided")
-	}
-
-	user, err := e.CreateCliUser("gcloud")
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cli user")
-	}
-	defer e.CleanupCliUser(user.Username)
-	ce := e.CreateCliUserPee(user)
-
-	gcloudUsernameEnv := fmt.Sprintf("GCLOUD_USER=%s", user.Username)
-
-	pathToConfig, err := initGoogleCloudEnvironment(ce, fmt.Sprintf("%s", gcpCredentials))
-	if err != nil {
-		return common.GetCommandFailureResponse(nil, err, false)
-	}
-
-	cliEnv := []string{
-		fmt.Sprintf("CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE=%s", pathToConfig),
-		gcloudUsernameEnv,
-	}
-
-	output, err := common.ExecuteCommand(e, request, cliEnv, "/bin/bash", "-c", command)
-	if err != nil {
-		return common.GetCommandFailureResponse(output, err, true)
-	}
-
-	return output, nil
-}
-
-func executeCoreAzureAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
-	credentials, err := ctx.GetCredentials("azure")
-	if err != nil {
-		return nil, err
-	}
-
-	// we currently don't support oauth. if there's an oauth token return err
-	if _, ok := credentials["Token"]; ok {
-		return nil, errors.New("az CLI currently does not support OAuth connections.")
-	}
-
-	appId, ok := credentials["app_id"]
-	if !ok {
-		return nil, errors.New("connection to Azure is invalid")
-	}
-
-	clientSecret, ok := credentials["client_secret"]
-	if !ok {
-		return nil, errors.New("connection to Azure is invalid")
-	}
-
-	tenantId, ok := credentials["tenant_id"]
-	if !ok {
-		return nil, errors.New("connection to Azure is invalid")
-	}
-
-	command, ok := request.Parameters[commandParameterName]
-	if !ok {
-		return nil, errors.New("command to Google Cloud CLI wasn't provided")
-	}
-
-	cliUser, err := e.CreateCliUser("az")
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cli user")
-	}
-	defer e.CleanupCliUser(cliUser.Username)
-	ce := e.CreateCliUserPee(cliUser)
-
-	azureUsernameEnv := fmt.Sprintf("AZURE_USER=%s", cliUser.Username)
-
-	loginCmd := fmt.Sprintf("login --service-principal -u %s -p %s --tenant %s", appId, clientSecret, tenantId)
-	if output, err := common.ExecuteCommand(ce, request, []string{}, common.ClisDir+"/az", strings.Split(loginCmd, " ")...); err != nil {
-		return common.GetCommandFailureResponse(output, err, false)
-	}
-
-	output, err := common.ExecuteCommand(e, request, []string{azureUsernameEnv}, "/bin/bash", "-c", command)
-	if err != nil {
-		return common.GetCommandFailureResponse(output, err, true)
-	}
-
-	return output, nil
-}
-
-func initKubernetesEnvironment(e *execution.PrivateExecutionEnvironment, environment []string, bearerToken string, apiServerURL string, verifyCertificate bool) ([]byte, error) {
+func initKubernetesEnvironment(e *execution.PrivateExecutionEnvironment, environment []string, credentials map[string]string) ([]byte, error) {
 
 	if log.IsLevelEnabled(log.TraceLevel) {
 		output, err := common.ExecuteBash(e, nil, environment, "whoami && pwd && env")
@@ -564,46 +382,19 @@ func initKubernetesEnvironment(e *execution.PrivateExecutionEnvironment, environ
 		log.Tracef("whoami && pwd && env output: %s", output)
 	}
 
-	cmd := fmt.Sprintf("%s/kubectl config set-cluster cluster --server=%s", common.ClisDir, apiServerURL)
-	if !verifyCertificate {
-		cmd = fmt.Sprintf("%s --insecure-skip-tls-verify=true", cmd)
-	}
-	if output, err := common.ExecuteBash(e, nil, environment, cmd); err != nil {
-		return output, err
-	}
-
-	cmd = fmt.Sprintf("%s/kubectl config set-credentials user --token=%s", common.ClisDir, bearerToken)
-	output, err := common.ExecuteBash(e, nil, environment, cmd)
+	kubeConfigContent, err := buildKubeConfig(credentials)
 	if err != nil {
-		return output, err
-	}
-
-	cmd = fmt.Sprintf("%s/kubectl config set-context ctx --cluster=cluster --user=user", common.ClisDir)
-	output, err = common.ExecuteBash(e, nil, environment, cmd)
-	if err != nil {
-		return output, err
+		return nil, err
 	}
 
-	cmd = fmt.Sprintf("%s/kubectl config use-context ctx", common.ClisDir)
-	output, err = common.ExecuteBash(e, nil, environment, cmd)
-	if err != nil {
-		return output, err
+	pathToKubeConfig := path.Join(e.GetHomeDirectory(), ".kube", "config")
+	if err = e.WriteToFile(pathToKubeConfig, kubeConfigContent, 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to write the kube config")
 	}
 
 	return nil, nil
 }
 
-func initGoogleCloudEnvironment(e *execution.PrivateExecutionEnvironment, credentials string) (string, error) {
-	pathToGCPConfigDirectory := path.Join(e.GetHomeDirectory(), ".gcp")
-	if err := e.CreateDirectory(pathToGCPConfigDirectory); err != nil {
-		return "", errors.Wrap(err, "Failed to create .gcp sub-directory: ")
-	}
-	pathToGCPConfig := path.Join(pathToGCPConfigDirectory, "config")
-
-	err := e.WriteToFile(pathToGCPConfig, []byte(credentials), 0600)
-	return pathToGCPConfig, err
-}
-
 func createTerraFormCredentialsFile(e *execution.PrivateExecutionEnvironment, apiServerURL string, token string) (string, error) {
 
 	// Create TerraForm credentials file
@@ -8,7 +8,15 @@ const (
 	mailToKey      = "To"
 	mailSubjectKey = "Subject"
 	mailContentKey = "Content"
+	includeLogKey  = "include_log"
+	maxLogSizeKey  = "max_log_size"
 
-	pythonRunnerPath = "/blink-core/python/runner.py"
-	nodejsRunnerPath = "/blink-core/nodejs/runner.js"
+	defaultMaxLogSize = 64 * 1024
+
+	pythonRunnerPath     = "/blink-core/python/runner.py"
+	nodejsRunnerPath     = "/blink-core/nodejs/runner.js"
+	powershellRunnerPath = "/blink-core/powershell/runner.ps1"
+	rubyRunnerPath       = "/blink-core/ruby/runner.rb"
+	goRunnerPath         = "/blink-core/golang/runner.go"
+	goBinaryPath         = "/usr/local/go/bin/go"
 )
@@ -0,0 +1,98 @@
+package implementation
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	clusterParameterName = "Cluster"
+	eksTokenPrefix       = "k8s-aws-v1."
+	eksClusterIdHeader   = "x-k8s-aws-id"
+	eksTokenPresignTime  = 60 * time.Second
+)
+
+// resolveKubernetesCredentials returns the kubernetes connection of the step. Without one, the credentials of
+// the requested EKS cluster are derived from the aws connection, using the same role assumption as the aws action.
+// A credential process of the aws connection runs as the cli user of the environment.
+func resolveKubernetesCredentials(ce *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) (map[string]string, error) {
+	credentials, err := ctx.GetCredentials("kubernetes")
+	if err == nil {
+		return credentials, nil
+	}
+
+	clusterName := request.Parameters[clusterParameterName]
+	if clusterName == "" {
+		return nil, err
+	}
+
+	awsCredentials, err := ctx.GetCredentials("aws")
+	if err != nil {
+		return nil, errors.New("either a kubernetes connection or an aws connection with a cluster name is required")
+	}
+
+	region, ok := request.Parameters[regionParameterName]
+	if !ok || region == "" {
+		region = defaultAwsRegion
+	}
+
+	awsCredentials, err = resolveAwsConnection(ctx, request, awsCredentials, region, nil, false, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed resolving aws credentials for the EKS cluster")
+	}
+	if awsCredentials, err = resolveAwsProcessCreds(ce, awsCredentials); err != nil {
+		return nil, errors.Wrap(err, "failed resolving aws credentials for the EKS cluster")
+	}
+
+	sess, err := newAwsSession(awsCredentials, region)
+	if err != nil {
+		return nil, err
+	}
+
+	return eksKubernetesCredentials(eks.New(sess), sts.New(sess), clusterName)
+}
+
+func eksKubernetesCredentials(eksSvc eksiface.EKSAPI, stsSvc stsiface.STSAPI, clusterName string) (map[string]string, error) {
+	result, err := eksSvc.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(clusterName)})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed describing EKS cluster %s", clusterName)
+	}
+
+	cluster := result.Cluster
+	if cluster == nil || cluster.Endpoint == nil || cluster.CertificateAuthority == nil || cluster.CertificateAuthority.Data == nil {
+		return nil, errors.Errorf("EKS cluster %s is missing an endpoint or a certificate authority", clusterName)
+	}
+
+	token, err := eksToken(stsSvc, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		kubernetesApiUrl:                   *cluster.Endpoint,
+		kubernetesCertificateAuthorityData: *cluster.CertificateAuthority.Data,
+		kubernetesBearerToken:              token,
+	}, nil
+}
+
+// eksToken mints a short-lived EKS token, which is a presigned sts:GetCallerIdentity url bound to the cluster name.
+func eksToken(stsSvc stsiface.STSAPI, clusterName string) (string, error) {
+	request, _ := stsSvc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	request.HTTPRequest.Header.Add(eksClusterIdHeader, clusterName)
+
+	presignedURL, err := request.Presign(eksTokenPresignTime)
+	if err != nil {
+		return "", errors.Wrap(err, "failed presigning the EKS token")
+	}
+
+	return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presignedURL)), nil
+}
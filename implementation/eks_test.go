@@ -0,0 +1,53 @@
+package implementation
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type EKSMockClient struct {
+	eksiface.EKSAPI
+}
+
+func (e *EKSMockClient) DescribeCluster(input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	if *input.Name != "prod" {
+		return nil, fmt.Errorf("cluster not found")
+	}
+	return &eks.DescribeClusterOutput{
+		Cluster: &eks.Cluster{
+			Endpoint:             aws.String("https://prod.eks.amazonaws.com"),
+			CertificateAuthority: &eks.Certificate{Data: aws.String("Y2E=")},
+		},
+	}, nil
+}
+
+func TestEksKubernetesCredentials(t *testing.T) {
+	sess, err := newAwsSession(map[string]string{awsAccessKeyId: "AKIDEXAMPLE", awsSecretAccessKey: "secret"}, "us-east-1")
+	require.Nil(t, err)
+	stsSvc := sts.New(sess)
+
+	credentials, err := eksKubernetesCredentials(&EKSMockClient{}, stsSvc, "prod")
+	require.Nil(t, err)
+	assert.Equal(t, "https://prod.eks.amazonaws.com", credentials[kubernetesApiUrl])
+	assert.Equal(t, "Y2E=", credentials[kubernetesCertificateAuthorityData])
+
+	token := credentials[kubernetesBearerToken]
+	require.True(t, strings.HasPrefix(token, eksTokenPrefix))
+	presignedURL, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, eksTokenPrefix))
+	require.Nil(t, err)
+	assert.Contains(t, string(presignedURL), "Action=GetCallerIdentity")
+	assert.Contains(t, string(presignedURL), "x-k8s-aws-id")
+
+	_, err = eksKubernetesCredentials(&EKSMockClient{}, stsSvc, "staging")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cluster not found")
+}
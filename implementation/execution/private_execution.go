@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"sync"
@@ -369,3 +370,14 @@ func StopPrivateExecution(request *plugin.ExecuteActionRequest) ([]byte, error)
 
 	return []byte("Success"), nil // Does not really matter what output we return here
 }
+
+// ChownTree hands the ownership of a directory tree to the executor, e.g. when a working directory
+// which lives in the session home is used by a CLI user.
+func (p *PrivateExecutionEnvironment) ChownTree(root string) error {
+	return filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(name, int(p.GetExecutorUid()), int(p.GetExecutorGid()))
+	})
+}
@@ -6,13 +6,14 @@ import (
 	"github.com/blinkops/blink-core/common"
 	"github.com/blinkops/blink-core/implementation/execution"
 	"github.com/blinkops/blink-core/implementation/fetch-file-source"
+	"github.com/blinkops/blink-core/implementation/githubapp"
 	"github.com/blinkops/blink-sdk/plugin"
 )
 
 const (
 	fileSourceGitHub    = "github"
 	gitHubTokenKey      = "token"
-	headerAuthorization = "'Authorization: token %s'"
+	headerAuthorization = "Authorization: token %s"
 	paramDelimiter      = "?"
 )
 
@@ -35,7 +36,7 @@ func FetchFile(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionConte
 
 	destination, err := fetch_file_source.GetFileDestination(e, fileUrl, request, paramDelimiter)
 
-	token, err := getConnnection(ctx)
+	token, err := getConnnection(e, ctx)
 
 	if err != nil {
 		return nil, err
@@ -51,13 +52,17 @@ func FetchFile(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionConte
 	return []byte(destination), nil
 }
 
-func getConnnection(ctx *plugin.ActionContext) (string, error) {
+func getConnnection(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext) (string, error) {
 	gitCredentials, err := ctx.GetCredentials(fileSourceGitHub)
 
 	if err != nil {
 		return "", err
 	}
 
+	if githubapp.IsConfigured(gitCredentials) {
+		return githubapp.InstallationToken(e.GetSessionId(), gitCredentials)
+	}
+
 	gitToken, ok := gitCredentials[gitHubTokenKey]
 
 	if !ok {
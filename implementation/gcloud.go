@@ -0,0 +1,137 @@
+package implementation
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	gcpCredentials               = "credentials"
+	gcpToken                     = "Token"
+	gcpImpersonateServiceAccount = "impersonate_service_account"
+	gcpProject                   = "project"
+	gcpRegion                    = "region"
+
+	projectParameterName                   = "Project"
+	impersonateServiceAccountParameterName = "Impersonate Service Account"
+)
+
+// the credential file types gcloud accepts through CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE
+var gcpCredentialFileTypes = map[string]bool{
+	"service_account":  true,
+	"external_account": true,
+	"authorized_user":  true,
+}
+
+// googleCloudAuth is the environment which authenticates gcloud, and the files (path to content) it refers to.
+type googleCloudAuth struct {
+	Environment []string
+	Files       map[string]string
+}
+
+func executeCoreGoogleCloudAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	credentials, err := ctx.GetCredentials("gcp")
+	if err != nil {
+		return nil, err
+	}
+
+	command, ok := request.Parameters[commandParameterName]
+	if !ok {
+		return nil, errors.New("command to Google Cloud CLI wasn't provided")
+	}
+
+	user, err := e.CreateCliUser("gcloud")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cli user")
+	}
+	defer e.CleanupCliUser(user.Username)
+	ce := e.CreateCliUserPee(user)
+
+	auth, err := buildGoogleCloudAuth(credentials, request.Parameters, path.Join(ce.GetHomeDirectory(), ".gcp"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = initGoogleCloudEnvironment(ce, auth); err != nil {
+		return common.GetCommandFailureResponse(nil, err, false)
+	}
+
+	cliEnv := append(auth.Environment, fmt.Sprintf("GCLOUD_USER=%s", user.Username))
+
+	output, err := common.ExecuteCommand(e, request, cliEnv, "/bin/bash", "-c", command)
+	if err != nil {
+		return common.GetCommandFailureResponse(output, err, true)
+	}
+
+	return output, nil
+}
+
+// buildGoogleCloudAuth authenticates gcloud with either a credential file of the connection (a service account key
+// or a workload identity federation config) or an OAuth access token. Impersonation, project and region are taken
+// from the action parameters, falling back to the connection.
+func buildGoogleCloudAuth(credentials map[string]string, parameters map[string]string, configDirectory string) (*googleCloudAuth, error) {
+	auth := &googleCloudAuth{
+		// gcloud keeps its state in the home of the CLI user rather than the session's
+		Environment: []string{fmt.Sprintf("CLOUDSDK_CONFIG=%s", path.Join(configDirectory, "gcloud"))},
+		Files:       map[string]string{},
+	}
+
+	if credentialFile := credentials[gcpCredentials]; credentialFile != "" {
+		var parsedCredentialFile struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(credentialFile), &parsedCredentialFile); err != nil {
+			return nil, errors.New("connection to GCP is invalid: the credentials aren't a json credential file")
+		}
+		if !gcpCredentialFileTypes[parsedCredentialFile.Type] {
+			return nil, errors.Errorf("connection to GCP is invalid: unsupported credential file type %q", parsedCredentialFile.Type)
+		}
+
+		configPath := path.Join(configDirectory, "config")
+		auth.Files[configPath] = credentialFile
+		auth.Environment = append(auth.Environment, fmt.Sprintf("CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE=%s", configPath))
+	} else if token := credentials[gcpToken]; token != "" {
+		tokenPath := path.Join(configDirectory, "access-token")
+		auth.Files[tokenPath] = token
+		auth.Environment = append(auth.Environment, fmt.Sprintf("CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=%s", tokenPath))
+	} else {
+		return nil, errors.New("connection to GCP is invalid")
+	}
+
+	setting := func(connectionKey string, parameterName string) string {
+		if parameters[parameterName] != "" {
+			return parameters[parameterName]
+		}
+		return credentials[connectionKey]
+	}
+
+	if serviceAccount := setting(gcpImpersonateServiceAccount, impersonateServiceAccountParameterName); serviceAccount != "" {
+		auth.Environment = append(auth.Environment, fmt.Sprintf("CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT=%s", serviceAccount))
+	}
+	if project := setting(gcpProject, projectParameterName); project != "" {
+		auth.Environment = append(auth.Environment, fmt.Sprintf("CLOUDSDK_CORE_PROJECT=%s", project))
+	}
+	if region := setting(gcpRegion, regionParameterName); region != "" {
+		auth.Environment = append(auth.Environment, fmt.Sprintf("CLOUDSDK_COMPUTE_REGION=%s", region))
+	}
+
+	return auth, nil
+}
+
+func initGoogleCloudEnvironment(e *execution.PrivateExecutionEnvironment, auth *googleCloudAuth) error {
+	for filePath, content := range auth.Files {
+		if err := e.CreateDirectory(path.Dir(filePath)); err != nil {
+			return errors.Wrap(err, "Failed to create .gcp sub-directory: ")
+		}
+		if err := e.WriteToFile(filePath, []byte(content), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package implementation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildGoogleCloudAuth(t *testing.T) {
+	serviceAccountKey := `{"type": "service_account", "project_id": "blink"}`
+	externalAccount := `{"type": "external_account", "audience": "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/blink/providers/aws"}`
+
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		parameters  map[string]string
+		expected    *googleCloudAuth
+		wantErr     string
+	}{
+		{
+			name:        "service account key with connection project",
+			credentials: map[string]string{gcpCredentials: serviceAccountKey, gcpProject: "blink"},
+			expected: &googleCloudAuth{
+				Environment: []string{
+					"CLOUDSDK_CONFIG=/home/gcloud/.gcp/gcloud",
+					"CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE=/home/gcloud/.gcp/config",
+					"CLOUDSDK_CORE_PROJECT=blink",
+				},
+				Files: map[string]string{"/home/gcloud/.gcp/config": serviceAccountKey},
+			},
+		},
+		{
+			name:        "external account with impersonation and parameter overrides",
+			credentials: map[string]string{gcpCredentials: externalAccount, gcpProject: "blink", gcpImpersonateServiceAccount: "ops@blink.iam.gserviceaccount.com"},
+			parameters:  map[string]string{projectParameterName: "blink-prod", regionParameterName: "europe-west1"},
+			expected: &googleCloudAuth{
+				Environment: []string{
+					"CLOUDSDK_CONFIG=/home/gcloud/.gcp/gcloud",
+					"CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE=/home/gcloud/.gcp/config",
+					"CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT=ops@blink.iam.gserviceaccount.com",
+					"CLOUDSDK_CORE_PROJECT=blink-prod",
+					"CLOUDSDK_COMPUTE_REGION=europe-west1",
+				},
+				Files: map[string]string{"/home/gcloud/.gcp/config": externalAccount},
+			},
+		},
+		{
+			name:        "oauth access token",
+			credentials: map[string]string{gcpToken: "ya29.token"},
+			parameters:  map[string]string{impersonateServiceAccountParameterName: "ops@blink.iam.gserviceaccount.com"},
+			expected: &googleCloudAuth{
+				Environment: []string{
+					"CLOUDSDK_CONFIG=/home/gcloud/.gcp/gcloud",
+					"CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=/home/gcloud/.gcp/access-token",
+					"CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT=ops@blink.iam.gserviceaccount.com",
+				},
+				Files: map[string]string{"/home/gcloud/.gcp/access-token": "ya29.token"},
+			},
+		},
+		{
+			name:        "unsupported credential file",
+			credentials: map[string]string{gcpCredentials: `{"type": "gdch_service_account"}`},
+			wantErr:     `unsupported credential file type "gdch_service_account"`,
+		},
+		{
+			name:        "no credentials",
+			credentials: map[string]string{gcpProject: "blink"},
+			wantErr:     "connection to GCP is invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := buildGoogleCloudAuth(tt.credentials, tt.parameters, "/home/gcloud/.gcp")
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, auth)
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package implementation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-core/implementation/githubapp"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	gitBranchParameterName           = "Branch"
+	gitBaseBranchParameterName       = "Base Branch"
+	gitFilesParameterName            = "Files"
+	gitCommitMessageParameterName    = "Commit Message"
+	gitAuthorNameParameterName       = "Author Name"
+	gitAuthorEmailParameterName      = "Author Email"
+	gitPushParameterName             = "Push"
+	gitOpenPullRequestParameterName  = "Open Pull Request"
+	gitPullRequestTitleParameterName = "Pull Request Title"
+	gitPullRequestBodyParameterName  = "Pull Request Body"
+
+	defaultGitAuthorName   = "Blink"
+	defaultGitAuthorEmail  = "noreply@blinkops.com"
+	gitRepositoryDirectory = "repository"
+
+	gitApiTimeout = 30 * time.Second
+)
+
+// gitOperationResult is the structured result of the clone, edit, commit, push and pull request flow.
+type gitOperationResult struct {
+	Branch         string `json:"branch"`
+	Changed        bool   `json:"changed"`
+	CommitSha      string `json:"commit_sha,omitempty"`
+	Pushed         bool   `json:"pushed"`
+	PullRequestUrl string `json:"pull_request_url,omitempty"`
+}
+
+// gitRepositoryPath is the owner/name (or the full group path on gitlab) of a repository and the host serving it.
+type gitRepositoryPath struct {
+	Host string
+	Path string
+}
+
+func executeCoreGITAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	var envList []string
+
+	cliUser, err := e.CreateCliUser("git")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cli user")
+	}
+	defer e.CleanupCliUser(cliUser.Username)
+	cliUserPee := e.CreateCliUserPee(cliUser)
+
+	envList = append(envList, fmt.Sprintf("GIT_USER=%s", cliUser.Username))
+
+	credentials, err := initGitCredentials(e, cliUserPee, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer credentials.Close()
+
+	if request.Parameters[commandParameterName] == "" && request.Parameters[repositoryParameterName] != "" {
+		result, err := executeGitOperation(cliUserPee, request, credentials)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(result)
+	}
+
+	command, ok := request.Parameters[commandParameterName]
+	if !ok {
+		return nil, errors.New("command to GIT CLI wasn't provided")
+	}
+
+	output, err := common.ExecuteCommand(e, request, envList, "/bin/bash", "-c", command)
+	if err != nil {
+		return common.GetCommandFailureResponse(output, err, true)
+	}
+
+	return output, nil
+}
+
+// executeGitOperation clones the repository as the CLI user, applies the file edits of the step on a branch,
+// commits and pushes them and optionally opens a pull (merge) request with the API of the connection.
+func executeGitOperation(ce *execution.PrivateExecutionEnvironment, request *plugin.ExecuteActionRequest, credentials *gitCredentials) (*gitOperationResult, error) {
+	repository := request.Parameters[repositoryParameterName]
+	files, err := parseGitFileEdits(request.Parameters[gitFilesParameterName])
+	if err != nil {
+		return nil, err
+	}
+
+	push, err := parseBoolParameter(request, gitPushParameterName, true)
+	if err != nil {
+		return nil, err
+	}
+	openPullRequest, err := parseBoolParameter(request, gitOpenPullRequestParameterName, false)
+	if err != nil {
+		return nil, err
+	}
+	if openPullRequest && (credentials.Token == "" || !push) {
+		return nil, errors.New("opening a pull request requires a github or gitlab connection and pushing the branch")
+	}
+
+	// the token of the connection is only sent to the api of the connection's own host
+	var repositoryPath gitRepositoryPath
+	if openPullRequest {
+		if repositoryPath, err = parseGitRepositoryPath(repository); err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(repositoryPath.Host, credentials.Host) {
+			return nil, errors.Errorf("the repository isn't on %s, the host of the %s connection", credentials.Host, credentials.Type)
+		}
+	}
+
+	// a pull request needs a branch of its own, otherwise the changes would be pushed straight to the base branch
+	baseBranch := request.Parameters[gitBaseBranchParameterName]
+	if openPullRequest && (request.Parameters[gitBranchParameterName] == "" || request.Parameters[gitBranchParameterName] == baseBranch) {
+		return nil, errors.New("opening a pull request requires a branch which differs from the base branch")
+	}
+	for _, branch := range []string{baseBranch, request.Parameters[gitBranchParameterName]} {
+		if err = validateGitBranch(ce, branch); err != nil {
+			return nil, err
+		}
+	}
+
+	directory := path.Join(ce.GetHomeDirectory(), gitRepositoryDirectory)
+	git := func(args ...string) (string, error) {
+		output, err := common.ExecuteCommand(ce, request, nil, common.ClisDir+"/git", append([]string{"-C", directory}, args...)...)
+		if err != nil {
+			_, err = common.GetCommandFailureResponse(output, err, false)
+			return "", errors.Wrapf(err, "git %s failed", args[0])
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	cloneArgs := []string{"clone", "--depth", "1"}
+	if baseBranch != "" {
+		cloneArgs = append(cloneArgs, "--branch", baseBranch)
+	}
+	cloneArgs = append(cloneArgs, "--", repository, directory)
+	if output, err := common.ExecuteCommand(ce, request, nil, common.ClisDir+"/git", cloneArgs...); err != nil {
+		_, err = common.GetCommandFailureResponse(output, err, false)
+		return nil, errors.Wrap(err, "failed cloning the repository")
+	}
+
+	if baseBranch == "" {
+		if baseBranch, err = git("rev-parse", "--abbrev-ref", "HEAD"); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &gitOperationResult{Branch: request.Parameters[gitBranchParameterName]}
+	if result.Branch == "" {
+		result.Branch = baseBranch
+	}
+	if openPullRequest && result.Branch == baseBranch {
+		return nil, errors.Errorf("opening a pull request requires a branch which differs from the base branch %s", baseBranch)
+	}
+	if result.Branch != baseBranch {
+		if _, err = git("checkout", "-B", result.Branch); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = applyGitFileEdits(ce, directory, files); err != nil {
+		return nil, err
+	}
+
+	if _, err = git("add", "--all"); err != nil {
+		return nil, err
+	}
+	status, err := git("status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	if status == "" {
+		return result, nil
+	}
+	result.Changed = true
+
+	message := request.Parameters[gitCommitMessageParameterName]
+	if message == "" {
+		return nil, errors.New("a commit message is required")
+	}
+	authorName := request.Parameters[gitAuthorNameParameterName]
+	if authorName == "" {
+		authorName = defaultGitAuthorName
+	}
+	authorEmail := request.Parameters[gitAuthorEmailParameterName]
+	if authorEmail == "" {
+		authorEmail = defaultGitAuthorEmail
+	}
+
+	if _, err = git("-c", "user.name="+authorName, "-c", "user.email="+authorEmail, "commit", "--message", message); err != nil {
+		return nil, err
+	}
+	if result.CommitSha, err = git("rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+
+	if !push {
+		return result, nil
+	}
+	if _, err = git("push", "origin", "HEAD:refs/heads/"+result.Branch); err != nil {
+		return nil, err
+	}
+	result.Pushed = true
+
+	if !openPullRequest {
+		return result, nil
+	}
+
+	title := request.Parameters[gitPullRequestTitleParameterName]
+	if title == "" {
+		title = strings.SplitN(message, "\n", 2)[0]
+	}
+
+	pullRequest := gitPullRequest{
+		Title: title,
+		Body:  request.Parameters[gitPullRequestBodyParameterName],
+		Head:  result.Branch,
+		Base:  baseBranch,
+	}
+	client := &http.Client{Timeout: gitApiTimeout}
+	if result.PullRequestUrl, err = openGitPullRequest(client, credentials.ApiUrl, credentials.Type, credentials.Token, repositoryPath.Path, pullRequest); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// gitCredentials are the git credentials of the step's github, gitlab or ssh connection, set up for a CLI user.
+// Host and ApiUrl are those of the github or gitlab connection.
+type gitCredentials struct {
+	Token  string
+	Type   string
+	Host   string
+	ApiUrl string
+	ssh    *sshSession
+}
+
+// initGitCredentials sets up the github or gitlab token, or otherwise the ssh key, of the step for the CLI user.
+// Close has to be called once git is done.
+func initGitCredentials(e *execution.PrivateExecutionEnvironment, ce *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext) (*gitCredentials, error) {
+	credentials := &gitCredentials{}
+
+	basicAuthCredentials, _ := ctx.GetCredentials("github")
+	credentials.Type = "github"
+	if basicAuthCredentials == nil {
+		basicAuthCredentials, _ = ctx.GetCredentials("gitlab")
+		credentials.Type = "gitlab"
+	}
+
+	var err error
+	if basicAuthCredentials != nil {
+		if credentials.Token, err = resolveGitToken(e, basicAuthCredentials, credentials.Type); err != nil {
+			return nil, err
+		}
+		if err = initBasicAuthGitCredentials(ce, basicAuthCredentials, credentials.Type, credentials.Token); err != nil {
+			return nil, err
+		}
+		credentials.Host = (&url.URL{Host: fmt.Sprint(extractGitHost(basicAuthCredentials, credentials.Type))}).Hostname()
+		credentials.ApiUrl = gitApiUrl(basicAuthCredentials, credentials.Type)
+	} else if sshCredentials, _ := ctx.GetCredentials("ssh"); sshCredentials != nil {
+		if credentials.ssh, err = initSshCredentials(ce, sshCredentials); err != nil {
+			return nil, err
+		}
+	}
+	return credentials, nil
+}
+
+func (c *gitCredentials) Close() {
+	c.ssh.Close()
+}
+
+// resolveGitToken returns the token of a github or gitlab connection, github connections of a github app
+// get an installation token which is shared by the steps of the execution.
+func resolveGitToken(e *execution.PrivateExecutionEnvironment, credentials map[string]string, authType string) (string, error) {
+	if authType == "github" && githubapp.IsConfigured(credentials) {
+		return githubapp.InstallationToken(e.GetSessionId(), credentials)
+	}
+
+	token := credentials["Token"]
+	if token == "" {
+		return "", errors.Errorf("%s basic-auth connection is missing a token", authType)
+	}
+	return token, nil
+}
+
+// parseGitFileEdits parses the yaml mapping of file paths to their new content, a null content deletes the file.
+func parseGitFileEdits(rawFiles string) (map[string]*string, error) {
+	files := map[string]*string{}
+	if strings.TrimSpace(rawFiles) == "" {
+		return files, nil
+	}
+
+	if err := yaml.Unmarshal([]byte(rawFiles), &files); err != nil {
+		return nil, errors.Wrap(err, "files must be a mapping of file paths to their content")
+	}
+	return files, nil
+}
+
+// applyGitFileEdits writes and deletes the files of the step as the CLI user, the content is staged outside of the
+// clone first. Symlinks of the repository are refused, they could point the edits outside of the clone.
+func applyGitFileEdits(ce *execution.PrivateExecutionEnvironment, directory string, files map[string]*string) error {
+	stagingDirectory, err := ce.CreateTempDirectory()
+	if err != nil {
+		return errors.Wrap(err, "failed creating a staging directory")
+	}
+	defer func() { _ = os.RemoveAll(stagingDirectory) }()
+
+	for name, content := range files {
+		filePath, err := gitRepositoryFilePath(directory, name)
+		if err != nil {
+			return err
+		}
+
+		if content == nil {
+			if _, err = os.Lstat(filepath.Dir(filePath)); os.IsNotExist(err) {
+				continue
+			}
+			if err = checkGitFileEditPath(directory, filePath); err != nil {
+				return errors.Wrapf(err, "refusing to delete %s", name)
+			}
+			if output, err := common.ExecuteCommand(ce, nil, nil, "/bin/rm", "-f", "--", filePath); err != nil {
+				return errors.Wrapf(err, "failed deleting %s: %s", name, strings.TrimSpace(string(output)))
+			}
+			continue
+		}
+
+		if err = ce.CreateDirectory(filepath.Dir(filePath)); err != nil {
+			return errors.Wrapf(err, "failed creating the directory of %s", name)
+		}
+		if err = checkGitFileEditPath(directory, filePath); err != nil {
+			return errors.Wrapf(err, "refusing to write %s", name)
+		}
+
+		stagedFile := filepath.Join(stagingDirectory, "content")
+		if err = ce.WriteToFile(stagedFile, []byte(*content), 0644); err != nil {
+			return errors.Wrapf(err, "failed staging %s", name)
+		}
+		if output, err := common.ExecuteCommand(ce, nil, nil, "/bin/cp", "--", stagedFile, filePath); err != nil {
+			return errors.Wrapf(err, "failed writing %s: %s", name, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// checkGitFileEditPath makes sure the directory of the file resolves inside the clone, outside of .git, and that
+// the file itself isn't a symlink or a directory.
+func checkGitFileEditPath(directory string, filePath string) error {
+	realDirectory, err := filepath.EvalSymlinks(directory)
+	if err != nil {
+		return err
+	}
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+
+	relativeParent, err := filepath.Rel(realDirectory, realParent)
+	if err != nil || relativeParent == ".." || strings.HasPrefix(relativeParent, "../") {
+		return errors.New("the path resolves outside of the repository")
+	}
+	if relativeParent == ".git" || strings.HasPrefix(relativeParent, ".git/") {
+		return errors.New("the path resolves into .git")
+	}
+
+	info, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return errors.New("the file is a symlink")
+	}
+	if info.IsDir() {
+		return errors.New("the file is a directory")
+	}
+	return nil
+}
+
+// validateGitBranch rejects names which git would read as an option or which aren't valid branch names.
+func validateGitBranch(ce *execution.PrivateExecutionEnvironment, branch string) error {
+	if branch == "" {
+		return nil
+	}
+	if strings.HasPrefix(branch, "-") {
+		return errors.Errorf("invalid branch name: %s", branch)
+	}
+	if _, err := common.ExecuteCommand(ce, nil, nil, common.ClisDir+"/git", "check-ref-format", "--branch", branch); err != nil {
+		return errors.Errorf("invalid branch name: %s", branch)
+	}
+	return nil
+}
+
+// gitRepositoryFilePath resolves a file of the step inside the clone, it may neither escape it nor touch .git.
+func gitRepositoryFilePath(directory string, name string) (string, error) {
+	relativePath := filepath.Clean("/" + name)[1:]
+	if relativePath == "" || relativePath == ".git" || strings.HasPrefix(relativePath, ".git/") {
+		return "", errors.Errorf("invalid file path: %s", name)
+	}
+	return filepath.Join(directory, relativePath), nil
+}
+
+// parseGitRepositoryPath supports https urls and scp like ssh urls (git@host:owner/name.git).
+func parseGitRepositoryPath(repository string) (gitRepositoryPath, error) {
+	var host, repositoryPath string
+	if parsedUrl, err := url.Parse(repository); err == nil && parsedUrl.Host != "" {
+		host, repositoryPath = parsedUrl.Hostname(), parsedUrl.Path
+	} else if at := strings.Index(repository, "@"); at >= 0 {
+		hostAndPath := repository[at+1:]
+		if colon := strings.Index(hostAndPath, ":"); colon >= 0 {
+			host, repositoryPath = hostAndPath[:colon], hostAndPath[colon+1:]
+		}
+	}
+
+	repositoryPath = strings.TrimSuffix(strings.Trim(repositoryPath, "/"), ".git")
+	if host == "" || !strings.Contains(repositoryPath, "/") {
+		return gitRepositoryPath{}, errors.Errorf("failed parsing the repository url: %s", repository)
+	}
+	return gitRepositoryPath{Host: host, Path: repositoryPath}, nil
+}
+
+// gitApiUrl derives the api of a github or gitlab connection from the connection itself, never from the repository.
+func gitApiUrl(credentials map[string]string, credentialsType string) string {
+	if credentialsType == "gitlab" {
+		return fmt.Sprintf("https://%s/api/v4", extractGitHost(credentials, credentialsType))
+	}
+	// github.com, github enterprise or the api url of a github app connection
+	return githubapp.ApiUrl(credentials)
+}
+
+type gitPullRequest struct {
+	Title string
+	Body  string
+	Head  string
+	Base  string
+}
+
+// openGitPullRequest opens a github pull request or a gitlab merge request and returns its url.
+func openGitPullRequest(client *http.Client, apiUrl string, credentialsType string, token string, repositoryPath string, pullRequest gitPullRequest) (string, error) {
+	var endpoint string
+	var payload map[string]string
+	if credentialsType == "gitlab" {
+		endpoint = fmt.Sprintf("%s/projects/%s/merge_requests", apiUrl, url.PathEscape(repositoryPath))
+		payload = map[string]string{
+			"source_branch": pullRequest.Head,
+			"target_branch": pullRequest.Base,
+			"title":         pullRequest.Title,
+			"description":   pullRequest.Body,
+		}
+	} else {
+		endpoint = fmt.Sprintf("%s/repos/%s/pulls", apiUrl, repositoryPath)
+		payload = map[string]string{
+			"head":  pullRequest.Head,
+			"base":  pullRequest.Base,
+			"title": pullRequest.Title,
+			"body":  pullRequest.Body,
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if credentialsType == "gitlab" {
+		httpRequest.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		httpRequest.Header.Set("Authorization", "token "+token)
+		httpRequest.Header.Set("Accept", "application/vnd.github.v3+json")
+	}
+
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed opening the pull request")
+	}
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading the pull request response")
+	}
+	if response.StatusCode != http.StatusCreated {
+		return "", errors.Errorf("failed opening the pull request, status %d: %s", response.StatusCode, string(responseBody))
+	}
+
+	// github returns html_url and gitlab web_url
+	var created struct {
+		HtmlUrl string `json:"html_url"`
+		WebUrl  string `json:"web_url"`
+	}
+	if err = json.Unmarshal(responseBody, &created); err != nil {
+		return "", errors.Wrap(err, "failed parsing the pull request response")
+	}
+	if created.HtmlUrl != "" {
+		return created.HtmlUrl, nil
+	}
+	return created.WebUrl, nil
+}
+
+func parseBoolParameter(request *plugin.ExecuteActionRequest, name string, defaultValue bool) (bool, error) {
+	rawValue, ok := request.Parameters[name]
+	if !ok || rawValue == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.ParseBool(rawValue)
+	if err != nil {
+		return false, errors.Errorf("%s must be true or false", name)
+	}
+	return value, nil
+}
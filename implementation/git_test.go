@@ -0,0 +1,182 @@
+package implementation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGitRepositoryPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		expected   gitRepositoryPath
+		wantErr    bool
+	}{
+		{name: "https", repository: "https://github.com/blinkops/blink-core.git", expected: gitRepositoryPath{Host: "github.com", Path: "blinkops/blink-core"}},
+		{name: "gitlab subgroup", repository: "https://gitlab.example.com/group/sub/project", expected: gitRepositoryPath{Host: "gitlab.example.com", Path: "group/sub/project"}},
+		{name: "scp like ssh", repository: "git@github.com:blinkops/blink-core.git", expected: gitRepositoryPath{Host: "github.com", Path: "blinkops/blink-core"}},
+		{name: "ssh url", repository: "ssh://git@github.com/blinkops/blink-core.git", expected: gitRepositoryPath{Host: "github.com", Path: "blinkops/blink-core"}},
+		{name: "missing owner", repository: "https://github.com/blink-core", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("test parseGitRepositoryPath(): "+tt.name, func(t *testing.T) {
+			repositoryPath, err := parseGitRepositoryPath(tt.repository)
+			if tt.wantErr {
+				assert.NotNil(t, err, tt.name)
+				return
+			}
+			require.Nil(t, err, tt.name)
+			assert.Equal(t, tt.expected, repositoryPath)
+		})
+	}
+}
+
+func TestGitRepositoryFilePath(t *testing.T) {
+	filePath, err := gitRepositoryFilePath("/home/git/repository", "../../etc/passwd")
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join("/home/git/repository", "etc/passwd"), filePath)
+
+	_, err = gitRepositoryFilePath("/home/git/repository", "./.git/config")
+	assert.NotNil(t, err)
+
+	_, err = gitRepositoryFilePath("/home/git/repository", "/")
+	assert.NotNil(t, err)
+}
+
+func TestParseGitFileEdits(t *testing.T) {
+	files, err := parseGitFileEdits("README.md: |\n  hello\nold.txt: null\n")
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "hello\n", *files["README.md"])
+	assert.Nil(t, files["old.txt"])
+
+	_, err = parseGitFileEdits("- README.md")
+	assert.NotNil(t, err)
+}
+
+func TestGitOperationPullRequestRequiresBranch(t *testing.T) {
+	credentials := &gitCredentials{Token: "token", Type: "github", Host: "github.com"}
+	for _, parameters := range []map[string]string{
+		{repositoryParameterName: "https://github.com/blinkops/example.git", gitOpenPullRequestParameterName: "true"},
+		{repositoryParameterName: "https://github.com/blinkops/example.git", gitOpenPullRequestParameterName: "true", gitBranchParameterName: "main", gitBaseBranchParameterName: "main"},
+	} {
+		_, err := executeGitOperation(nil, &plugin.ExecuteActionRequest{Parameters: parameters}, credentials)
+		assert.EqualError(t, err, "opening a pull request requires a branch which differs from the base branch")
+	}
+}
+
+func TestOpenGitPullRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		credentialsType string
+		expectedPath    string
+		expectedAuth    string
+		expectedPayload map[string]string
+		response        string
+		status          int
+		expectedUrl     string
+		wantErr         bool
+	}{
+		{
+			name:            "github",
+			credentialsType: "github",
+			expectedPath:    "/repos/blinkops/blink-core/pulls",
+			expectedAuth:    "token secret",
+			expectedPayload: map[string]string{"head": "feature", "base": "main", "title": "title", "body": "body"},
+			response:        `{"html_url": "https://github.com/blinkops/blink-core/pull/1"}`,
+			status:          http.StatusCreated,
+			expectedUrl:     "https://github.com/blinkops/blink-core/pull/1",
+		},
+		{
+			name:            "gitlab",
+			credentialsType: "gitlab",
+			expectedPath:    "/projects/group%2Fsub%2Fproject/merge_requests",
+			expectedAuth:    "Bearer secret",
+			expectedPayload: map[string]string{"source_branch": "feature", "target_branch": "main", "title": "title", "description": "body"},
+			response:        `{"web_url": "https://gitlab.com/group/sub/project/-/merge_requests/1"}`,
+			status:          http.StatusCreated,
+			expectedUrl:     "https://gitlab.com/group/sub/project/-/merge_requests/1",
+		},
+		{
+			name:            "already exists",
+			credentialsType: "github",
+			expectedPath:    "/repos/blinkops/blink-core/pulls",
+			expectedAuth:    "token secret",
+			expectedPayload: map[string]string{"head": "feature", "base": "main", "title": "title", "body": "body"},
+			response:        `{"message": "Validation Failed"}`,
+			status:          http.StatusUnprocessableEntity,
+			wantErr:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test openGitPullRequest(): "+tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, tt.expectedPath, r.URL.EscapedPath())
+				assert.Equal(t, tt.expectedAuth, r.Header.Get("Authorization"))
+
+				body, _ := ioutil.ReadAll(r.Body)
+				payload := map[string]string{}
+				assert.Nil(t, json.Unmarshal(body, &payload))
+				assert.Equal(t, tt.expectedPayload, payload)
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			repositoryPath := "blinkops/blink-core"
+			if tt.credentialsType == "gitlab" {
+				repositoryPath = "group/sub/project"
+			}
+			pullRequest := gitPullRequest{Title: "title", Body: "body", Head: "feature", Base: "main"}
+
+			pullRequestUrl, err := openGitPullRequest(server.Client(), server.URL, tt.credentialsType, "secret", repositoryPath, pullRequest)
+			if tt.wantErr {
+				assert.NotNil(t, err, tt.name)
+				return
+			}
+			require.Nil(t, err, tt.name)
+			assert.Equal(t, tt.expectedUrl, pullRequestUrl)
+		})
+	}
+}
+
+func TestGitApiUrl(t *testing.T) {
+	assert.Equal(t, "https://api.github.com", gitApiUrl(map[string]string{}, "github"))
+	assert.Equal(t, "https://api.github.com", gitApiUrl(map[string]string{"REQUEST_URL": "https://github.com"}, "github"))
+	assert.Equal(t, "https://github.example.com/api/v3", gitApiUrl(map[string]string{"REQUEST_URL": "https://github.example.com"}, "github"))
+	assert.Equal(t, "https://gitlab.com/api/v4", gitApiUrl(map[string]string{}, "gitlab"))
+	assert.Equal(t, "https://gitlab.example.com/api/v4", gitApiUrl(map[string]string{"REQUEST_URL": "https://gitlab.example.com"}, "gitlab"))
+}
+
+func TestCheckGitFileEditPath(t *testing.T) {
+	directory := t.TempDir()
+	outside := t.TempDir()
+	require.Nil(t, os.MkdirAll(filepath.Join(directory, ".git"), 0755))
+	require.Nil(t, os.MkdirAll(filepath.Join(directory, "docs"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(directory, "docs", "README.md"), []byte("hello"), 0644))
+	require.Nil(t, os.Symlink(outside, filepath.Join(directory, "escape")))
+	require.Nil(t, os.Symlink(".git", filepath.Join(directory, "dotgit")))
+	require.Nil(t, os.Symlink("/etc/passwd", filepath.Join(directory, "passwd")))
+
+	assert.Nil(t, checkGitFileEditPath(directory, filepath.Join(directory, "docs", "README.md")))
+	assert.Nil(t, checkGitFileEditPath(directory, filepath.Join(directory, "docs", "new.md")))
+	assert.Nil(t, checkGitFileEditPath(directory, filepath.Join(directory, "new.md")))
+
+	assert.NotNil(t, checkGitFileEditPath(directory, filepath.Join(directory, "escape", "file")))
+	assert.NotNil(t, checkGitFileEditPath(directory, filepath.Join(directory, "dotgit", "config")))
+	assert.NotNil(t, checkGitFileEditPath(directory, filepath.Join(directory, "passwd")))
+	assert.NotNil(t, checkGitFileEditPath(directory, filepath.Join(directory, "docs")))
+}
@@ -0,0 +1,205 @@
+package githubapp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AppIdKey          = "app_id"
+	InstallationIdKey = "installation_id"
+	PrivateKeyKey     = "private_key"
+	ApiUrlKey         = "api_url"
+	requestUrlKey     = "REQUEST_URL"
+
+	defaultApiUrl = "https://api.github.com"
+
+	// github rejects app tokens which are valid for more than 10 minutes, iat is backdated for clock drift
+	jwtLifetime  = 9 * time.Minute
+	jwtClockSkew = time.Minute
+
+	// a cached installation token is renewed before it expires during a command
+	tokenRenewalMargin = 5 * time.Minute
+	requestTimeout     = 30 * time.Second
+)
+
+var (
+	httpClient = &http.Client{Timeout: requestTimeout}
+	now        = time.Now
+
+	tokensMutex sync.Mutex
+	tokens      = map[string]*cachedToken{}
+)
+
+type cachedToken struct {
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// IsConfigured tells whether a github connection authenticates as a github app rather than with a token.
+func IsConfigured(credentials map[string]string) bool {
+	return credentials[AppIdKey] != "" || credentials[InstallationIdKey] != "" || credentials[PrivateKeyKey] != ""
+}
+
+// InstallationToken returns an installation token of the github app of the connection. Tokens are minted on demand
+// and cached per execution session, so the steps of an execution share a token until it is about to expire.
+func InstallationToken(sessionId string, credentials map[string]string) (string, error) {
+	appId := credentials[AppIdKey]
+	installationId := credentials[InstallationIdKey]
+	privateKey := credentials[PrivateKeyKey]
+	if appId == "" || installationId == "" || privateKey == "" {
+		return "", errors.Errorf("github app connections require %s, %s and %s", AppIdKey, InstallationIdKey, PrivateKeyKey)
+	}
+
+	apiUrl := ApiUrl(credentials)
+	entry := getCachedToken(strings.Join([]string{sessionId, apiUrl, appId, installationId}, "|"))
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.token != "" && now().Add(tokenRenewalMargin).Before(entry.expiresAt) {
+		return entry.token, nil
+	}
+
+	jwt, err := signAppJwt(appId, privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	token, expiresAt, err := createInstallationToken(apiUrl, installationId, jwt)
+	if err != nil {
+		return "", err
+	}
+
+	entry.token, entry.expiresAt = token, expiresAt
+	return token, nil
+}
+
+func getCachedToken(key string) *cachedToken {
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+
+	// tokens of sessions which are over expire on their own
+	for cachedKey, cached := range tokens {
+		if cachedKey != key && !cached.expiresAt.IsZero() && now().After(cached.expiresAt) {
+			delete(tokens, cachedKey)
+		}
+	}
+
+	entry, ok := tokens[key]
+	if !ok {
+		entry = &cachedToken{}
+		tokens[key] = entry
+	}
+	return entry
+}
+
+// ApiUrl is the api_url of the connection, or the api of the github enterprise server the connection points to.
+func ApiUrl(credentials map[string]string) string {
+	if apiUrl := credentials[ApiUrlKey]; apiUrl != "" {
+		return strings.TrimSuffix(apiUrl, "/")
+	}
+
+	requestUrl, err := url.Parse(credentials[requestUrlKey])
+	if err != nil || requestUrl.Host == "" || requestUrl.Host == "github.com" || requestUrl.Host == "api.github.com" {
+		return defaultApiUrl
+	}
+	return fmt.Sprintf("https://%s/api/v3", requestUrl.Host)
+}
+
+func signAppJwt(appId string, privateKey string) (string, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	issuedAt := now().Add(-jwtClockSkew)
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": issuedAt.Unix(),
+		"exp": issuedAt.Add(jwtLifetime).Unix(),
+		"iss": appId,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed signing the github app jwt")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey reads the PKCS#1 key github generates for apps, or a PKCS#8 conversion of it.
+func parsePrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("the github app private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing the github app private key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the github app private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func createInstallationToken(apiUrl string, installationId string, jwt string) (string, time.Time, error) {
+	endpoint := fmt.Sprintf("%s/app/installations/%s/access_tokens", apiUrl, url.PathEscape(installationId))
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(nil))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "failed creating a github app installation token")
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "failed reading the github app installation token")
+	}
+	if response.StatusCode != http.StatusCreated {
+		return "", time.Time{}, errors.Errorf("failed creating a github app installation token, status %d: %s", response.StatusCode, string(body))
+	}
+
+	var created struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err = json.Unmarshal(body, &created); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "failed parsing the github app installation token")
+	}
+	if created.Token == "" {
+		return "", time.Time{}, errors.New("github returned an empty installation token")
+	}
+	return created.Token, created.ExpiresAt, nil
+}
@@ -0,0 +1,135 @@
+package githubapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiUrl(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		expected    string
+	}{
+		{name: "default", credentials: map[string]string{}, expected: "https://api.github.com"},
+		{name: "github.com", credentials: map[string]string{requestUrlKey: "https://github.com"}, expected: "https://api.github.com"},
+		{name: "enterprise server", credentials: map[string]string{requestUrlKey: "https://github.example.com"}, expected: "https://github.example.com/api/v3"},
+		{name: "explicit api url", credentials: map[string]string{ApiUrlKey: "https://github.example.com/api/v3/", requestUrlKey: "https://github.com"}, expected: "https://github.example.com/api/v3"},
+	}
+
+	for _, tt := range tests {
+		t.Run("test ApiUrl(): "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ApiUrl(tt.credentials))
+		})
+	}
+}
+
+func TestInstallationToken(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	privateKeyPem := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+
+	currentTime := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return currentTime }
+	defer func() { now = time.Now }()
+
+	minted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/app/installations/42/access_tokens", r.URL.Path)
+
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		require.Len(t, parts, 3)
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.Nil(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		assert.Nil(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature))
+
+		rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.Nil(t, err)
+		var claims struct {
+			Iat int64  `json:"iat"`
+			Exp int64  `json:"exp"`
+			Iss string `json:"iss"`
+		}
+		require.Nil(t, json.Unmarshal(rawClaims, &claims))
+		assert.Equal(t, "1234", claims.Iss)
+		assert.Equal(t, currentTime.Add(-time.Minute).Unix(), claims.Iat)
+		assert.Equal(t, currentTime.Add(8*time.Minute).Unix(), claims.Exp)
+
+		minted++
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token": "ghs_%d", "expires_at": "%s"}`, minted, currentTime.Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	credentials := map[string]string{
+		AppIdKey:          "1234",
+		InstallationIdKey: "42",
+		PrivateKeyKey:     privateKeyPem,
+		ApiUrlKey:         server.URL,
+	}
+
+	token, err := InstallationToken("session-1", credentials)
+	require.Nil(t, err)
+	assert.Equal(t, "ghs_1", token)
+
+	// cached for the session
+	token, err = InstallationToken("session-1", credentials)
+	require.Nil(t, err)
+	assert.Equal(t, "ghs_1", token)
+
+	// other sessions get their own token
+	token, err = InstallationToken("session-2", credentials)
+	require.Nil(t, err)
+	assert.Equal(t, "ghs_2", token)
+
+	// renewed shortly before it expires
+	currentTime = currentTime.Add(56 * time.Minute)
+	token, err = InstallationToken("session-1", credentials)
+	require.Nil(t, err)
+	assert.Equal(t, "ghs_3", token)
+	assert.Equal(t, 3, minted)
+
+	_, err = InstallationToken("session-1", map[string]string{AppIdKey: "1234"})
+	assert.NotNil(t, err)
+}
+
+func TestInstallationTokenFailure(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.Nil(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message": "A JSON web token could not be decoded"}`))
+	}))
+	defer server.Close()
+
+	_, err = InstallationToken("session-failure", map[string]string{
+		AppIdKey:          "1234",
+		InstallationIdKey: "42",
+		PrivateKeyKey:     string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ApiUrlKey:         server.URL,
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "status 401")
+}
@@ -0,0 +1,56 @@
+package implementation
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+func executeCoreGoAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	code, ok := request.Parameters[codeKey]
+	if !ok {
+		return nil, errors.New("no code provided for execution")
+	}
+
+	runnerSource, err := os.ReadFile(goRunnerPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading the go runner")
+	}
+
+	// The user code and the runner are compiled together as package main in the session home.
+	buildDirectory, err := e.CreateTempDirectory()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed creating the go build directory")
+	}
+	defer func(name string) { _ = os.RemoveAll(name) }(buildDirectory)
+
+	codeFile := path.Join(buildDirectory, "main.go")
+	if err = e.WriteFile([]byte(code), codeFile); err != nil {
+		return nil, errors.Wrap(err, "failed writing the go code")
+	}
+
+	runnerFile := path.Join(buildDirectory, "blink_runner.go")
+	if err = e.WriteFile(runnerSource, runnerFile); err != nil {
+		return nil, errors.Wrap(err, "failed writing the go runner")
+	}
+
+	environment := []string{
+		fmt.Sprintf("GOCACHE=%s", path.Join(e.GetHomeDirectory(), ".cache", "go-build")),
+		fmt.Sprintf("GOPATH=%s", path.Join(e.GetHomeDirectory(), "go")),
+		"GOTOOLCHAIN=local",
+	}
+
+	// building separately keeps the output of the toolchain (e.g. go: downloading) out of the runner json
+	binaryFile := path.Join(buildDirectory, "blink_runner")
+	output, err := common.ExecuteCommand(e, request, environment, goBinaryPath, "build", "-o", binaryFile, codeFile, runnerFile)
+	if err != nil {
+		return common.GetCommandFailureResponse(output, err, true)
+	}
+
+	return executeRunnerCodeAction(e, ctx, request, "blink-go-", environment, binaryFile)
+}
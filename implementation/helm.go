@@ -0,0 +1,110 @@
+package implementation
+
+import (
+	"path"
+	"strings"
+
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	helmOperationParameterName = "Operation"
+	releaseParameterName       = "Release"
+	chartParameterName         = "Chart"
+	versionParameterName       = "Version"
+	repositoryNameParameter    = "Repository Name"
+	repositoryURLParameter     = "Repository URL"
+	valuesParameterName        = "Values"
+	revisionParameterName      = "Revision"
+
+	helmOperationCommand        = "command"
+	helmOperationRepoAdd        = "repo_add"
+	helmOperationUpgradeInstall = "upgrade_install"
+	helmOperationRollback       = "rollback"
+	helmOperationStatus         = "status"
+	helmOperationHistory        = "history"
+)
+
+func executeCoreHelmAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	operation := request.Parameters[helmOperationParameterName]
+	if operation == "" || operation == helmOperationCommand {
+		if _, ok := request.Parameters[commandParameterName]; !ok {
+			return nil, errors.New("command to helm wasn't provided")
+		}
+		return executeKubernetesCli(e, ctx, request, "helm", "HELM_USER", nil)
+	}
+
+	return executeKubernetesCli(e, ctx, request, "helm", "HELM_USER", func(ce *execution.PrivateExecutionEnvironment) (string, error) {
+		return buildHelmCommand(ce, operation, request.Parameters)
+	})
+}
+
+// buildHelmCommand returns the helm script of a structured operation. The CLI user's home only lives for one step,
+// so the repository (when given) is added and updated as part of every operation.
+func buildHelmCommand(ce *execution.PrivateExecutionEnvironment, operation string, parameters map[string]string) (string, error) {
+	var commands []string
+
+	repositoryName, repositoryURL := parameters[repositoryNameParameter], parameters[repositoryURLParameter]
+	if repositoryURL != "" {
+		if repositoryName == "" {
+			return "", errors.New("a repository name is required with the repository url")
+		}
+		commands = append(commands,
+			quoteCommand([]string{"helm", "repo", "add", repositoryName, repositoryURL}),
+			quoteCommand([]string{"helm", "repo", "update"}))
+	}
+
+	var namespaceArgs []string
+	if namespace := parameters[namespaceParameterName]; namespace != "" {
+		namespaceArgs = []string{"--namespace", namespace}
+	}
+
+	release := parameters[releaseParameterName]
+	if release == "" && operation != helmOperationRepoAdd {
+		return "", errors.Errorf("a release is required for the %s operation", operation)
+	}
+
+	switch operation {
+	case helmOperationRepoAdd:
+		if repositoryURL == "" {
+			return "", errors.Errorf("a repository url is required for the %s operation", operation)
+		}
+		commands = append(commands, quoteCommand([]string{"helm", "search", "repo", repositoryName}))
+	case helmOperationUpgradeInstall:
+		chart := parameters[chartParameterName]
+		if chart == "" {
+			return "", errors.New("a chart is required for the upgrade_install operation")
+		}
+
+		args := append([]string{"helm", "upgrade", "--install", release, chart}, namespaceArgs...)
+		if len(namespaceArgs) > 0 {
+			args = append(args, "--create-namespace")
+		}
+		if version := parameters[versionParameterName]; version != "" {
+			args = append(args, "--version", version)
+		}
+		if values := parameters[valuesParameterName]; values != "" {
+			valuesPath := path.Join(ce.GetHomeDirectory(), "helm-values.yaml")
+			if err := ce.WriteToFile(valuesPath, []byte(values), 0600); err != nil {
+				return "", errors.Wrap(err, "failed creating the values file")
+			}
+			args = append(args, "--values", valuesPath)
+		}
+		commands = append(commands, quoteCommand(args))
+	case helmOperationRollback:
+		args := []string{"helm", "rollback", release}
+		if revision := parameters[revisionParameterName]; revision != "" {
+			args = append(args, revision)
+		}
+		commands = append(commands, quoteCommand(append(args, namespaceArgs...)))
+	case helmOperationStatus, helmOperationHistory:
+		args := append([]string{"helm", operation, release, "--output", "json"}, namespaceArgs...)
+		commands = append(commands, quoteCommand(args))
+	default:
+		return "", errors.Errorf("unsupported helm operation: %s", operation)
+	}
+
+	return strings.Join(commands, " && "), nil
+}
@@ -10,6 +10,7 @@ import (
 	description2 "github.com/blinkops/blink-sdk/plugin/description"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"os"
 	"path"
 )
 
@@ -107,7 +108,18 @@ func (p *CorePlugin) executeAction(ctx *plugin.ActionContext, request *plugin.Ex
 		return nil, errors.New("action is not supported: " + request.Name)
 	}
 
-	return actionHandler(session, ctx, request)
+	if references := request.Parameters[vaultSecretsParameterName]; references != "" {
+		secrets, err := resolveVaultSecrets(session, ctx, references)
+		if err != nil {
+			return nil, err
+		}
+
+		common.RegisterRequestSecrets(request, secrets)
+		defer common.UnregisterRequestSecrets(request)
+	}
+
+	output, err := actionHandler(session, ctx, request)
+	return common.RedactOutput(request, output), err
 }
 
 func (p *CorePlugin) TestCredentials(_ map[string]*connections.ConnectionInstance) (*plugin.CredentialsValidationResponse, error) {
@@ -121,6 +133,10 @@ func NewCorePlugin(rootPluginDirectory string) (*CorePlugin, error) {
 
 	pluginConfig := config.GetConfig()
 
+	if err := common.LoadCoreConfig(os.Getenv(config.ConfigurationPathEnvVar)); err != nil {
+		return nil, err
+	}
+
 	description, err := description2.LoadPluginDescriptionFromDisk(path.Join(rootPluginDirectory, pluginConfig.Plugin.PluginDescriptionFilePath))
 	if err != nil {
 		return nil, err
@@ -152,11 +168,16 @@ func NewCorePlugin(rootPluginDirectory string) (*CorePlugin, error) {
 		"vault":         executeCoreVaultAction,
 		"terraform":     executeCoreTerraFormAction,
 		"kubectl_apply": executeCoreKubernetesApplyAction,
+		"helm":          executeCoreHelmAction,
 		"gcloud":        executeCoreGoogleCloudAction,
 		"az":            executeCoreAzureAction,
+		"ssh":           executeCoreSshAction,
 		"fetch_file":    executeCoreFetchFileAction,
 		"nodejs":        executeCoreNodejsAction,
 		"install":       executeInstallAction,
+		"pwsh":          executeCorePowerShellAction,
+		"ruby":          executeCoreRubyAction,
+		"go":            executeCoreGoAction,
 	}
 
 	return &CorePlugin{
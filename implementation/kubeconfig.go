@@ -0,0 +1,114 @@
+package implementation
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	kubernetesApiUrl                   = "kubernetes_api_url"
+	kubernetesBearerToken              = "bearer_token"
+	kubernetesVerify                   = "verify"
+	kubernetesKubeConfig               = "kubeconfig"
+	kubernetesCertificateAuthorityData = "certificate_authority_data"
+	kubernetesClientCertificateData    = "client_certificate_data"
+	kubernetesClientKeyData            = "client_key_data"
+)
+
+type kubeConfig struct {
+	APIVersion     string              `yaml:"apiVersion"`
+	Kind           string              `yaml:"kind"`
+	Clusters       []kubeConfigCluster `yaml:"clusters"`
+	Users          []kubeConfigUser    `yaml:"users"`
+	Contexts       []kubeConfigContext `yaml:"contexts"`
+	CurrentContext string              `yaml:"current-context"`
+}
+
+type kubeConfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+		InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify,omitempty"`
+	} `yaml:"cluster"`
+}
+
+type kubeConfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Token                 string `yaml:"token,omitempty"`
+		ClientCertificateData string `yaml:"client-certificate-data,omitempty"`
+		ClientKeyData         string `yaml:"client-key-data,omitempty"`
+	} `yaml:"user"`
+}
+
+type kubeConfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	} `yaml:"context"`
+}
+
+// buildKubeConfig renders the kubeconfig of a kubernetes connection. A connection may carry a complete
+// kubeconfig (which is how exec based auth plugins are configured), or the api url with either a
+// bearer token or a client certificate and key. When a CA is supplied the server is verified against it.
+func buildKubeConfig(credentials map[string]string) ([]byte, error) {
+	if rawKubeConfig := credentials[kubernetesKubeConfig]; rawKubeConfig != "" {
+		if err := yaml.Unmarshal([]byte(rawKubeConfig), &kubeConfig{}); err != nil {
+			return nil, errors.Wrap(err, "connection to K8S is invalid, failed parsing the kubeconfig")
+		}
+		return []byte(rawKubeConfig), nil
+	}
+
+	apiServerURL := credentials[kubernetesApiUrl]
+	if apiServerURL == "" {
+		return nil, errors.New("connection to K8S is invalid, the api url is missing")
+	}
+
+	cluster := kubeConfigCluster{Name: "cluster"}
+	cluster.Cluster.Server = apiServerURL
+	cluster.Cluster.CertificateAuthorityData = encodeKubeConfigData(credentials[kubernetesCertificateAuthorityData])
+	if cluster.Cluster.CertificateAuthorityData == "" {
+		verify, err := strconv.ParseBool(credentials[kubernetesVerify])
+		cluster.Cluster.InsecureSkipTLSVerify = err == nil && !verify
+	}
+
+	user := kubeConfigUser{Name: "user"}
+	user.User.Token = credentials[kubernetesBearerToken]
+	user.User.ClientCertificateData = encodeKubeConfigData(credentials[kubernetesClientCertificateData])
+	user.User.ClientKeyData = encodeKubeConfigData(credentials[kubernetesClientKeyData])
+
+	if (user.User.ClientCertificateData == "") != (user.User.ClientKeyData == "") {
+		return nil, errors.New("connection to K8S is invalid, both client certificate and key are required")
+	}
+	if user.User.Token == "" && user.User.ClientCertificateData == "" {
+		return nil, errors.New("connection to K8S is invalid, either a bearer token or a client certificate is required")
+	}
+
+	context := kubeConfigContext{Name: "ctx"}
+	context.Context.Cluster = cluster.Name
+	context.Context.User = user.Name
+
+	return yaml.Marshal(kubeConfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeConfigCluster{cluster},
+		Users:          []kubeConfigUser{user},
+		Contexts:       []kubeConfigContext{context},
+		CurrentContext: context.Name,
+	})
+}
+
+// encodeKubeConfigData accepts both PEM and already base64 encoded data, kubeconfig expects the latter.
+func encodeKubeConfigData(data string) string {
+	data = strings.TrimSpace(data)
+	if strings.HasPrefix(data, "-----BEGIN") {
+		return base64.StdEncoding.EncodeToString([]byte(data + "\n"))
+	}
+	return data
+}
@@ -0,0 +1,80 @@
+package implementation
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestBuildKubeConfig(t *testing.T) {
+	caPem := "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		wantErr     string
+		verify      func(t *testing.T, config kubeConfig)
+	}{
+		{
+			name:        "bearer token without verification",
+			credentials: map[string]string{kubernetesApiUrl: "https://k8s:6443", kubernetesBearerToken: "token", kubernetesVerify: "false"},
+			verify: func(t *testing.T, config kubeConfig) {
+				assert.Equal(t, "https://k8s:6443", config.Clusters[0].Cluster.Server)
+				assert.True(t, config.Clusters[0].Cluster.InsecureSkipTLSVerify)
+				assert.Equal(t, "token", config.Users[0].User.Token)
+				assert.Equal(t, "ctx", config.CurrentContext)
+			},
+		},
+		{
+			name: "client certificate verified against the supplied CA",
+			credentials: map[string]string{
+				kubernetesApiUrl:                   "https://k8s:6443",
+				kubernetesVerify:                   "false",
+				kubernetesCertificateAuthorityData: caPem,
+				kubernetesClientCertificateData:    "Y2VydA==",
+				kubernetesClientKeyData:            "a2V5",
+			},
+			verify: func(t *testing.T, config kubeConfig) {
+				assert.False(t, config.Clusters[0].Cluster.InsecureSkipTLSVerify)
+				assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(caPem+"\n")), config.Clusters[0].Cluster.CertificateAuthorityData)
+				assert.Equal(t, "Y2VydA==", config.Users[0].User.ClientCertificateData)
+				assert.Equal(t, "a2V5", config.Users[0].User.ClientKeyData)
+			},
+		},
+		{
+			name:        "full kubeconfig is used as is",
+			credentials: map[string]string{kubernetesKubeConfig: "apiVersion: v1\nkind: Config\ncurrent-context: eks\n"},
+			verify: func(t *testing.T, config kubeConfig) {
+				assert.Equal(t, "eks", config.CurrentContext)
+			},
+		},
+		{
+			name:        "client certificate without key",
+			credentials: map[string]string{kubernetesApiUrl: "https://k8s:6443", kubernetesClientCertificateData: "Y2VydA=="},
+			wantErr:     "both client certificate and key are required",
+		},
+		{
+			name:        "no user credentials",
+			credentials: map[string]string{kubernetesApiUrl: "https://k8s:6443"},
+			wantErr:     "either a bearer token or a client certificate is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test buildKubeConfig(): "+tt.name, func(t *testing.T) {
+			content, err := buildKubeConfig(tt.credentials)
+			if tt.wantErr != "" {
+				require.NotNil(t, err, tt.name)
+				assert.Contains(t, err.Error(), tt.wantErr, tt.name)
+				return
+			}
+
+			require.Nil(t, err, tt.name)
+			config := kubeConfig{}
+			require.Nil(t, yaml.Unmarshal(content, &config))
+			tt.verify(t, config)
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package implementation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	dryRunParameterName         = "Dry Run"
+	diffParameterName           = "Diff"
+	serverSideParameterName     = "Server Side"
+	fieldManagerParameterName   = "Field Manager"
+	namespaceParameterName      = "Namespace"
+	pruneSelectorParameterName  = "Prune Selector"
+	waitForRolloutParameterName = "Wait For Rollout"
+	rolloutTimeoutParameterName = "Rollout Timeout"
+	templateEngineParameterName = "Template Engine"
+	sourceURLParameterName      = "Source URL"
+	kustomizeParameterName      = "Kustomize"
+	structuredParameterName     = "Structured Output"
+
+	templateEngineGo       = "go"
+	templateEngineEnvsubst = "envsubst"
+
+	defaultRolloutTimeout = "5m"
+)
+
+var (
+	envsubstVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.\-]*)\}`)
+	// e.g. "deployment.apps/nginx configured" or "service/nginx created (server dry run)"
+	applyResultLine = regexp.MustCompile(`^([a-z0-9.\-]+)/(\S+) ([a-z\-]+)(?: \((?:server )?dry run\))?$`)
+)
+
+// kinds which kubectl rollout status knows how to wait for
+var rolloutKinds = map[string]string{
+	"Deployment":  "deployment",
+	"StatefulSet": "statefulset",
+	"DaemonSet":   "daemonset",
+}
+
+type manifestObject struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name         string `yaml:"name"`
+		GenerateName string `yaml:"generateName"`
+		Namespace    string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+type applyResult struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Action    string `json:"action"`
+}
+
+type kubectlApplyOptions struct {
+	Kustomize        string
+	StructuredOutput bool
+	DryRun           string
+	Diff             bool
+	ServerSide       bool
+	FieldManager     string
+	Namespace        string
+	PruneSelector    string
+	WaitForRollout   bool
+	RolloutTimeout   string
+}
+
+func executeCoreKubernetesApplyAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	options, err := getKubectlApplyOptions(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var applyFileContents string
+	var objects []manifestObject
+	if options.Kustomize == "" {
+		if applyFileContents, err = loadApplyManifest(e, ctx, request); err != nil {
+			return nil, err
+		}
+
+		applyFileContents, err = renderManifest(applyFileContents, request.Parameters[templateEngineParameterName], ctx.GetAllContextEntries(), request.Parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		if objects, err = parseManifestObjects(applyFileContents); err != nil {
+			return nil, err
+		}
+	}
+
+	output, err := kubectl(e, ctx, request, func(ce *execution.PrivateExecutionEnvironment) (string, error) {
+		if options.Kustomize != "" {
+			command := buildKubectlApplyCommand(options.Kustomize, options, nil)
+			return command, enforcePolicies(request, command, common.UnknownDeletions)
+		}
+
+		tempPath := path.Join(ce.GetHomeDirectory(), "kubectl-apply")
+		err := ce.WriteFile([]byte(applyFileContents), tempPath)
+		if err != nil {
+			return "", errors.Wrap(err, "failed creating the apply file")
+		}
+		command := buildKubectlApplyCommand(tempPath, options, objects)
+		return command, enforcePolicies(request, command, common.UnknownDeletions)
+	})
+	if err != nil || !options.StructuredOutput || options.Diff {
+		return output, err
+	}
+
+	return json.Marshal(parseApplyResults(output, objects, options.Namespace))
+}
+
+// loadApplyManifest returns the manifest given in the file parameter, or fetches it from the source url
+// using the same sources as the fetch_file action.
+func loadApplyManifest(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) (string, error) {
+	if applyFileContents := request.Parameters[fileParameterName]; applyFileContents != "" {
+		return applyFileContents, nil
+	}
+
+	sourceURL := request.Parameters[sourceURLParameterName]
+	if sourceURL == "" {
+		return "", errors.New("can't run apply action without a file, a source url or a kustomization")
+	}
+
+	fetchRequest := &plugin.ExecuteActionRequest{
+		Name:       request.Name,
+		Parameters: map[string]string{"url": sourceURL},
+		Timeout:    request.Timeout,
+	}
+	filePath, err := fetchFile(e, ctx, fetchRequest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed fetching the manifest")
+	}
+	defer func(name string) { _ = os.Remove(name) }(string(filePath))
+
+	applyFileContents, err := os.ReadFile(string(filePath))
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading the fetched manifest")
+	}
+
+	if len(applyFileContents) == 0 {
+		return "", errors.New("can't run apply action with empty file")
+	}
+
+	return string(applyFileContents), nil
+}
+
+// renderManifest substitutes values from the execution context and the action parameters. The go engine
+// exposes them as {{ .Context.key }} and {{ .Parameters.name }}, envsubst replaces ${key} with the context
+// entry or the parameter of that name and leaves unknown variables untouched.
+func renderManifest(manifest string, engine string, contextEntries map[string]interface{}, parameters map[string]string) (string, error) {
+	switch engine {
+	case "", "none":
+		return manifest, nil
+	case templateEngineGo:
+		manifestTemplate, err := template.New("manifest").Option("missingkey=error").Parse(manifest)
+		if err != nil {
+			return "", errors.Wrap(err, "failed parsing the manifest template")
+		}
+
+		rendered := &bytes.Buffer{}
+		data := map[string]interface{}{"Context": contextEntries, "Parameters": parameters}
+		if err = manifestTemplate.Execute(rendered, data); err != nil {
+			return "", errors.Wrap(err, "failed rendering the manifest template")
+		}
+		return rendered.String(), nil
+	case templateEngineEnvsubst:
+		return envsubstVariable.ReplaceAllStringFunc(manifest, func(variable string) string {
+			name := envsubstVariable.FindStringSubmatch(variable)[1]
+			if value, ok := parameters[name]; ok {
+				return value
+			}
+			if value, ok := contextEntries[name]; ok {
+				if stringValue, ok := value.(string); ok {
+					return stringValue
+				}
+				marshaledValue, _ := json.Marshal(value)
+				return string(marshaledValue)
+			}
+			return variable
+		}), nil
+	default:
+		return "", errors.Errorf("invalid template engine %s, expected one of none, go or envsubst", engine)
+	}
+}
+
+// parseApplyResults turns the lines kubectl prints per object into structured results, the kind and namespace
+// are taken from the matching manifest object when there is one.
+func parseApplyResults(output []byte, objects []manifestObject, defaultNamespace string) []applyResult {
+	results := []applyResult{}
+	for _, line := range strings.Split(string(output), "\n") {
+		match := applyResultLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		resource, name, action := match[1], match[2], match[3]
+		result := applyResult{Kind: resource, Name: name, Namespace: defaultNamespace, Action: action}
+		for _, object := range objects {
+			if object.Metadata.Name == name && strings.EqualFold(object.Kind, strings.SplitN(resource, ".", 2)[0]) {
+				result.Kind = object.Kind
+				result.Namespace = objectNamespace(object, defaultNamespace)
+				break
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func getKubectlApplyOptions(request *plugin.ExecuteActionRequest) (kubectlApplyOptions, error) {
+	options := kubectlApplyOptions{
+		Kustomize:      request.Parameters[kustomizeParameterName],
+		DryRun:         request.Parameters[dryRunParameterName],
+		FieldManager:   request.Parameters[fieldManagerParameterName],
+		Namespace:      request.Parameters[namespaceParameterName],
+		PruneSelector:  request.Parameters[pruneSelectorParameterName],
+		RolloutTimeout: request.Parameters[rolloutTimeoutParameterName],
+	}
+	options.StructuredOutput, _ = strconv.ParseBool(request.Parameters[structuredParameterName])
+	options.Diff, _ = strconv.ParseBool(request.Parameters[diffParameterName])
+	options.ServerSide, _ = strconv.ParseBool(request.Parameters[serverSideParameterName])
+	options.WaitForRollout, _ = strconv.ParseBool(request.Parameters[waitForRolloutParameterName])
+
+	switch options.DryRun {
+	case "", "none", "client", "server":
+	default:
+		return options, errors.Errorf("invalid dry run mode %s, expected one of none, client or server", options.DryRun)
+	}
+
+	if options.RolloutTimeout == "" {
+		options.RolloutTimeout = defaultRolloutTimeout
+	}
+
+	return options, nil
+}
+
+// buildKubectlApplyCommand returns the script which runs the apply (or the diff preview) of the manifest file
+// or kustomization, followed by waiting for the rollout of the applied workloads when requested.
+func buildKubectlApplyCommand(source string, options kubectlApplyOptions, objects []manifestObject) string {
+	sourceArgs := []string{"-f", source}
+	if options.Kustomize != "" {
+		sourceArgs = []string{"-k", source}
+	}
+
+	var namespaceArgs []string
+	if options.Namespace != "" {
+		namespaceArgs = []string{"-n", options.Namespace}
+	}
+
+	if options.Diff {
+		args := append(append([]string{"kubectl", "diff"}, sourceArgs...), namespaceArgs...)
+		if options.ServerSide {
+			args = append(args, "--server-side")
+		}
+		// kubectl diff exits with 1 when there are differences, which is the expected result of a preview
+		return quoteCommand(args) + " || [ $? -eq 1 ]"
+	}
+
+	args := append(append([]string{"kubectl", "apply"}, sourceArgs...), namespaceArgs...)
+	if options.ServerSide {
+		args = append(args, "--server-side")
+	}
+	if options.FieldManager != "" {
+		args = append(args, "--field-manager="+options.FieldManager)
+	}
+	if options.DryRun != "" && options.DryRun != "none" {
+		args = append(args, "--dry-run="+options.DryRun)
+	}
+	if options.PruneSelector != "" {
+		args = append(args, "--prune", "-l", options.PruneSelector)
+	}
+
+	commands := []string{quoteCommand(args)}
+	if options.WaitForRollout && (options.DryRun == "" || options.DryRun == "none") {
+		for _, object := range objects {
+			resource, ok := rolloutKinds[object.Kind]
+			if !ok {
+				continue
+			}
+
+			rolloutArgs := []string{"kubectl", "rollout", "status", fmt.Sprintf("%s/%s", resource, object.Metadata.Name), "--timeout=" + options.RolloutTimeout}
+			if namespace := objectNamespace(object, options.Namespace); namespace != "" {
+				rolloutArgs = append(rolloutArgs, "-n", namespace)
+			}
+			commands = append(commands, quoteCommand(rolloutArgs))
+		}
+	}
+
+	return strings.Join(commands, " && ")
+}
+
+func objectNamespace(object manifestObject, defaultNamespace string) string {
+	if object.Metadata.Namespace != "" {
+		return object.Metadata.Namespace
+	}
+	return defaultNamespace
+}
+
+// parseManifestObjects decodes and validates every yaml document of the manifest, empty documents are skipped.
+func parseManifestObjects(manifest string) ([]manifestObject, error) {
+	var objects []manifestObject
+
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(manifest)))
+	for index := 0; ; index++ {
+		object := manifestObject{}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed parsing yaml document #%d", index+1)
+		}
+
+		if object.Kind == "" && object.APIVersion == "" && object.Metadata.Name == "" && object.Metadata.GenerateName == "" {
+			continue
+		}
+
+		if err = validateManifestObject(object); err != nil {
+			return nil, errors.Wrapf(err, "invalid yaml document #%d", index+1)
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+func validateManifestObject(object manifestObject) error {
+	if object.APIVersion == "" {
+		return errors.New("apiVersion is missing")
+	}
+	if object.Kind == "" {
+		return errors.New("kind is missing")
+	}
+	if object.Metadata.Name == "" && object.Metadata.GenerateName == "" && !strings.HasSuffix(object.Kind, "List") {
+		return errors.Errorf("%s is missing metadata.name", object.Kind)
+	}
+	return nil
+}
+
+func quoteCommand(args []string) string {
+	quotedArgs := make([]string, len(args))
+	for i, arg := range args {
+		quotedArgs[i] = common.ShellQuote(arg)
+	}
+	return strings.Join(quotedArgs, " ")
+}
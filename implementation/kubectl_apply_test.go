@@ -0,0 +1,100 @@
+package implementation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderManifest(t *testing.T) {
+	contextEntries := map[string]interface{}{"image": "nginx:1.21", "replicas": 3}
+	parameters := map[string]string{"Namespace": "web"}
+
+	tests := []struct {
+		name     string
+		engine   string
+		manifest string
+		expected string
+		wantErr  string
+	}{
+		{
+			name:     "no engine",
+			manifest: "image: ${image}",
+			expected: "image: ${image}",
+		},
+		{
+			name:     "go template",
+			engine:   templateEngineGo,
+			manifest: "image: {{ .Context.image }}\nnamespace: {{ .Parameters.Namespace }}",
+			expected: "image: nginx:1.21\nnamespace: web",
+		},
+		{
+			name:     "go template with a missing key",
+			engine:   templateEngineGo,
+			manifest: "image: {{ .Context.tag }}",
+			wantErr:  "failed rendering the manifest template",
+		},
+		{
+			name:     "envsubst",
+			engine:   templateEngineEnvsubst,
+			manifest: "image: ${image}\nreplicas: ${replicas}\nnamespace: ${Namespace}\nunknown: ${unknown}",
+			expected: "image: nginx:1.21\nreplicas: 3\nnamespace: web\nunknown: ${unknown}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test renderManifest(): "+tt.name, func(t *testing.T) {
+			rendered, err := renderManifest(tt.manifest, tt.engine, contextEntries, parameters)
+			if tt.wantErr != "" {
+				require.NotNil(t, err, tt.name)
+				assert.Contains(t, err.Error(), tt.wantErr, tt.name)
+				return
+			}
+			require.Nil(t, err, tt.name)
+			assert.Equal(t, tt.expected, rendered)
+		})
+	}
+}
+
+func TestParseManifestObjects(t *testing.T) {
+	objects, err := parseManifestObjects("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n---\n---\napiVersion: v1\nkind: Service\nmetadata:\n  name: web\n")
+	require.Nil(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "Deployment", objects[0].Kind)
+	assert.Equal(t, "prod", objects[0].Metadata.Namespace)
+
+	_, err = parseManifestObjects("apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n---\napiVersion: v1\nmetadata:\n  name: broken\n")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid yaml document #2: kind is missing")
+}
+
+func TestParseApplyResults(t *testing.T) {
+	objects := []manifestObject{{Kind: "Deployment"}}
+	objects[0].Metadata.Name = "web"
+	objects[0].Metadata.Namespace = "prod"
+
+	output := "deployment.apps/web configured\nservice/web created (server dry run)\nWaiting for deployment \"web\" rollout to finish\n"
+	results := parseApplyResults([]byte(output), objects, "default")
+
+	assert.Equal(t, []applyResult{
+		{Kind: "Deployment", Name: "web", Namespace: "prod", Action: "configured"},
+		{Kind: "service", Name: "web", Namespace: "default", Action: "created"},
+	}, results)
+}
+
+func TestBuildKubectlApplyCommand(t *testing.T) {
+	objects := []manifestObject{{Kind: "Deployment"}, {Kind: "Service"}}
+	objects[0].Metadata.Name = "web"
+	objects[1].Metadata.Name = "web"
+
+	options := kubectlApplyOptions{ServerSide: true, FieldManager: "blink", Namespace: "prod", PruneSelector: "app=web", WaitForRollout: true, RolloutTimeout: "2m"}
+	assert.Equal(t,
+		"'kubectl' 'apply' '-f' '/tmp/apply' '-n' 'prod' '--server-side' '--field-manager=blink' '--prune' '-l' 'app=web' && "+
+			"'kubectl' 'rollout' 'status' 'deployment/web' '--timeout=2m' '-n' 'prod'",
+		buildKubectlApplyCommand("/tmp/apply", options, objects))
+
+	assert.Equal(t,
+		"'kubectl' 'diff' '-k' 'github.com/org/repo/overlays/prod' || [ $? -eq 1 ]",
+		buildKubectlApplyCommand("github.com/org/repo/overlays/prod", kubectlApplyOptions{Diff: true, Kustomize: "github.com/org/repo/overlays/prod"}, nil))
+}
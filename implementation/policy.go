@@ -0,0 +1,40 @@
+package implementation
+
+import (
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+)
+
+// enforcePolicies checks the command of a step against the policy guardrails of config.yaml before it runs.
+func enforcePolicies(request *plugin.ExecuteActionRequest, command string, deletions int) error {
+	return common.EvaluatePolicies(common.PolicyInput{
+		Action:     request.Name,
+		Command:    command,
+		Parameters: request.Parameters,
+		Deletions:  deletions,
+	})
+}
+
+// enforceFanOutPolicies checks the command once per target of a fan-out with the parameter of the target set to it,
+// so fanning out doesn't bypass the policies of a single target, e.g. a policy on the Region parameter.
+func enforceFanOutPolicies(request *plugin.ExecuteActionRequest, command string, parameterName string, targets []string) error {
+	for _, target := range targets {
+		parameters := make(map[string]string, len(request.Parameters)+1)
+		for name, value := range request.Parameters {
+			parameters[name] = value
+		}
+		parameters[parameterName] = target
+
+		err := common.EvaluatePolicies(common.PolicyInput{
+			Action:     request.Name,
+			Command:    command,
+			Parameters: parameters,
+			Deletions:  common.UnknownDeletions,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "%s %s", parameterName, target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package implementation
+
+import (
+	"testing"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnforceFanOutPolicies(t *testing.T) {
+	previousConfig := common.GetCoreConfig()
+	defer common.SetCoreConfig(previousConfig)
+	common.SetCoreConfig(&common.CoreConfig{Policies: common.Policies{{
+		Name:       "no-gov-cloud",
+		Actions:    []string{"aws"},
+		Parameters: map[string]string{regionParameterName: "us-gov-*"},
+		Effect:     common.PolicyEffectDeny,
+	}}})
+
+	request := &plugin.ExecuteActionRequest{Name: "aws", Parameters: map[string]string{regionParameterName: "us-east-1", regionsParameterName: "us-east-1,us-gov-west-1"}}
+
+	assert.NoError(t, enforcePolicies(request, "aws s3 ls", common.UnknownDeletions))
+	assert.NoError(t, enforceFanOutPolicies(request, "aws s3 ls", regionParameterName, []string{"us-east-1", "eu-west-1"}))
+
+	err := enforceFanOutPolicies(request, "aws s3 ls", regionParameterName, []string{"us-east-1", "us-gov-west-1"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Region us-gov-west-1: blocked by policy no-gov-cloud")
+	}
+	assert.Equal(t, "us-east-1", request.Parameters[regionParameterName], "the parameters of the step aren't changed")
+}
@@ -0,0 +1,10 @@
+package implementation
+
+import (
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+)
+
+func executeCoreRubyAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	return executeRunnerCodeAction(e, ctx, request, "blink-rb-", nil, "/usr/bin/ruby", rubyRunnerPath)
+}
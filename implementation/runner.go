@@ -0,0 +1,117 @@
+package implementation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// executeRunnerCodeAction hands the code, context and connections of the step to a runner process
+// using the RunnerCodeStructure json contract, and applies the RunnerCodeResponse it prints.
+// The input file path is appended to the runner command as "--input <path>".
+func executeRunnerCodeAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, tempFilePrefix string, environment []string, name string, args ...string) ([]byte, error) {
+	code, ok := request.Parameters[codeKey]
+	if !ok {
+		return nil, errors.New("no code provided for execution")
+	}
+
+	maxLogSize, err := getMaxLogSize(request)
+	if err != nil {
+		return nil, err
+	}
+
+	structToBeMarshaled := RunnerCodeStructure{
+		Code:                code,
+		Context:             ctx.GetAllContextEntries(),
+		Connections:         ctx.GetAllConnections(),
+		ResolvedConnections: resolveRunnerConnections(e, ctx),
+	}
+	rawJsonBytes, err := json.Marshal(structToBeMarshaled)
+	if err != nil {
+		log.Error("Failed to marshal the code execution request, err: ", err)
+		return nil, err
+	}
+
+	filePath, err := e.WriteToTempFile(rawJsonBytes, tempFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer func(name string) { _ = os.Remove(name) }(filePath)
+
+	args = append(args, "--input", filePath)
+	// the runner json is redacted once decoded, masking its raw bytes could break it or miss escaped secrets
+	output, err := common.ExecuteCommandUnredacted(e, request, environment, name, args...)
+	if err != nil {
+		return common.GetCommandFailureResponse(common.RedactSecrets(request, output), err, true)
+	}
+
+	resultJson := RunnerCodeResponse{}
+	if err = json.Unmarshal(output, &resultJson); err != nil {
+		log.Error("Failed to unmarshal result, err: ", err)
+		return nil, err
+	}
+	redactRunnerResponse(request, &resultJson)
+
+	runnerLog := capRunnerLog(resultJson.Log, maxLogSize)
+
+	if resultJson.Error != "" {
+		return common.GetCommandFailureResponse([]byte(runnerLog), errors.New(resultJson.Error), true)
+	}
+
+	ctx.ReplaceContext(resultJson.Context)
+
+	if includeLog, _ := strconv.ParseBool(request.Parameters[includeLogKey]); includeLog {
+		return json.Marshal(RunnerCodeOutput{Output: resultJson.Output, Log: runnerLog})
+	}
+
+	if runnerLog != "" {
+		log.Debugf("%s action log (%d bytes): %s", request.Name, len(runnerLog), runnerLog)
+	}
+
+	return []byte(resultJson.Output), nil
+}
+
+func redactRunnerResponse(request *plugin.ExecuteActionRequest, response *RunnerCodeResponse) {
+	response.Log = string(common.RedactSecrets(request, []byte(response.Log)))
+	response.Output = string(common.RedactOutput(request, []byte(response.Output)))
+	response.Error = string(common.RedactSecrets(request, []byte(response.Error)))
+	for key, value := range response.Context {
+		response.Context[key] = common.RedactSecretsInValue(request, value)
+	}
+}
+
+func getMaxLogSize(request *plugin.ExecuteActionRequest) (int, error) {
+	rawMaxLogSize, ok := request.Parameters[maxLogSizeKey]
+	if !ok || rawMaxLogSize == "" {
+		return defaultMaxLogSize, nil
+	}
+
+	maxLogSize, err := strconv.Atoi(rawMaxLogSize)
+	if err != nil || maxLogSize < 0 {
+		return 0, fmt.Errorf("invalid %s parameter: %s", maxLogSizeKey, rawMaxLogSize)
+	}
+
+	return maxLogSize, nil
+}
+
+// capRunnerLog keeps the tail of the log, which is usually the part explaining how the code ended.
+// The cut moves forward to the next rune so that multi-byte characters are never split.
+func capRunnerLog(runnerLog string, maxLogSize int) string {
+	if len(runnerLog) <= maxLogSize {
+		return runnerLog
+	}
+
+	start := len(runnerLog) - maxLogSize
+	for start < len(runnerLog) && !utf8.RuneStart(runnerLog[start]) {
+		start++
+	}
+	return "..." + runnerLog[start:]
+}
@@ -0,0 +1,76 @@
+package implementation
+
+import (
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAwsRegion = "us-east-1"
+	awsRegion        = "region"
+)
+
+// resolveRunnerConnections prepares ready to use credentials of the well known connection types,
+// so the code runners can hand out authenticated clients instead of every script re-implementing
+// role assumption and friends. A credential process runs as the user of the environment, which runs the code
+// anyway. Connections which fail to resolve are skipped and logged.
+func resolveRunnerConnections(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext) map[string]map[string]string {
+	resolved := map[string]map[string]string{}
+
+	if credentials, err := ctx.GetCredentials("aws"); err == nil {
+		if awsConnection, err := resolveRunnerAwsConnection(e, ctx, credentials); err != nil {
+			log.Warnf("failed resolving aws connection for runner: %v", err)
+		} else {
+			resolved["aws"] = awsConnection
+		}
+	}
+
+	if credentials, err := ctx.GetCredentials("kubernetes"); err == nil {
+		if kubeConfigContent, err := buildKubeConfig(credentials); err != nil {
+			log.Warnf("failed resolving kubernetes connection for runner: %v", err)
+		} else {
+			resolved["kubernetes"] = map[string]string{"kubeconfig": string(kubeConfigContent)}
+		}
+	}
+
+	if credentials, err := ctx.GetCredentials("vault"); err == nil {
+		resolved["vault"] = map[string]string{
+			"url":   credentials[vaultAddress],
+			"token": credentials[vaultToken],
+		}
+	}
+
+	if credentials, err := ctx.GetCredentials("core-mail"); err == nil {
+		resolved["smtp"] = map[string]string{
+			"host":     credentials["smtpHost"],
+			"port":     credentials["smtpPort"],
+			"username": credentials["email"],
+			"password": credentials["password"],
+		}
+	}
+
+	return resolved
+}
+
+func resolveRunnerAwsConnection(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, credentials map[string]string) (map[string]string, error) {
+	region := credentials[awsRegion]
+	if region == "" {
+		region = defaultAwsRegion
+	}
+
+	resolvedCredentials, err := resolveAwsConnection(ctx, nil, credentials, region, nil, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedCredentials, err = resolveAwsProcessCreds(e, resolvedCredentials); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"access_key_id":     resolvedCredentials[awsAccessKeyId],
+		"secret_access_key": resolvedCredentials[awsSecretAccessKey],
+		"session_token":     resolvedCredentials[awsSessionToken],
+		"region":            region,
+	}, nil
+}
@@ -0,0 +1,55 @@
+package implementation
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapRunnerLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		runnerLog  string
+		maxLogSize int
+		expected   string
+	}{
+		{name: "short log", runnerLog: "done", maxLogSize: 10, expected: "done"},
+		{name: "exact size", runnerLog: "done", maxLogSize: 4, expected: "done"},
+		{name: "keeps the tail", runnerLog: "starting\ndone", maxLogSize: 4, expected: "...done"},
+		{name: "empty limit", runnerLog: "done", maxLogSize: 0, expected: "..."},
+		{name: "multi-byte character at the cut", runnerLog: "héllo wörld", maxLogSize: 4, expected: "...rld"},
+		{name: "multi-byte characters only", runnerLog: "日本語", maxLogSize: 4, expected: "...語"},
+	}
+
+	for _, tt := range tests {
+		t.Run("test capRunnerLog(): "+tt.name, func(t *testing.T) {
+			capped := capRunnerLog(tt.runnerLog, tt.maxLogSize)
+			assert.Equal(t, tt.expected, capped)
+			assert.True(t, utf8.ValidString(capped))
+		})
+	}
+}
+
+func TestRedactRunnerResponse(t *testing.T) {
+	request := &plugin.ExecuteActionRequest{Name: "python"}
+	common.RegisterRequestSecrets(request, map[string]string{"TOKEN": `s3"cr\et`, "FLAG": "output"})
+	defer common.UnregisterRequestSecrets(request)
+
+	response := RunnerCodeResponse{
+		Context: map[string]interface{}{"token": `s3"cr\et`, "nested": map[string]interface{}{"mode": "output"}, "count": 3.0},
+		Log:     `printed s3"cr\et`,
+		Output:  `{"token": "s3\"cr\\et", "ok": true}`,
+		Error:   "failed with output",
+	}
+	redactRunnerResponse(request, &response)
+
+	assert.Equal(t, RunnerCodeResponse{
+		Context: map[string]interface{}{"token": "***", "nested": map[string]interface{}{"mode": "***"}, "count": 3.0},
+		Log:     "printed ***",
+		Output:  `{"ok":true,"token":"***"}`,
+		Error:   "failed with ***",
+	}, response)
+}
@@ -0,0 +1,372 @@
+package implementation
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshKey        = "key"
+	sshUsername   = "username"
+	sshPassphrase = "passphrase"
+	sshKnownHosts = "known_hosts"
+
+	sshAgentPath = "/usr/bin/ssh-agent"
+	sshAddPath   = "/usr/bin/ssh-add"
+	// keys expire in the agent even if it outlives the step
+	sshAgentKeyLifetime = "3600"
+
+	opensshKeyMagic = "openssh-key-v1\x00"
+
+	// the known_hosts of a CLI user holds a few entries per host it reached
+	maxKnownHostsSize = 1024 * 1024
+)
+
+var (
+	// knownHostsStoreDirectory keeps the host keys which were trusted on first use, one file per ssh connection.
+	knownHostsStoreDirectory = "/var/lib/blink/known_hosts"
+	knownHostsStoreMutex     sync.Mutex
+
+	sshAgentPidPattern = regexp.MustCompile(`SSH_AGENT_PID=(\d+)`)
+)
+
+// sshSession is the ssh setup of a CLI user, Close persists newly trusted host keys and stops the agent.
+type sshSession struct {
+	pee            *execution.PrivateExecutionEnvironment
+	knownHostsFile string
+	storeFile      string
+	agentPid       int
+}
+
+// initSshCredentials writes the key of the ssh connection and an ssh config to the home of the CLI user.
+// Host keys are verified against the known_hosts of the connection, or when it has none, against the host keys
+// which were trusted on first use by earlier steps of the connection. Keys with a passphrase are loaded into
+// an ssh-agent of the CLI user so that the decrypted key is never written to disk.
+func initSshCredentials(pee *execution.PrivateExecutionEnvironment, credentials map[string]string) (*sshSession, error) {
+	key := credentials[sshKey]
+	if key == "" {
+		return nil, errors.New("missing ssh key")
+	}
+
+	usr := credentials[sshUsername]
+	if usr == "" {
+		return nil, errors.New("missing ssh username")
+	}
+
+	sshDir := path.Join(pee.GetHomeDirectory(), ".ssh")
+	if err := pee.CreateDirectory(sshDir); err != nil {
+		return nil, errors.Wrap(err, "failed creating .ssh directory")
+	}
+	if err := os.Chmod(sshDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed chmod .ssh directory")
+	}
+
+	keyFileName, err := sshKeyFileName([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	keyFile := path.Join(sshDir, keyFileName)
+	if !strings.HasSuffix(key, "\n") {
+		// ssh refuses keys without the trailing newline
+		key += "\n"
+	}
+	if err = pee.WriteToFile(keyFile, []byte(key), 0600); err != nil {
+		return nil, err
+	}
+
+	session := &sshSession{pee: pee, knownHostsFile: path.Join(sshDir, "known_hosts")}
+	strictHostKeyChecking := "yes"
+	knownHosts := credentials[sshKnownHosts]
+	if strings.TrimSpace(knownHosts) == "" {
+		strictHostKeyChecking = "accept-new"
+		session.storeFile = knownHostsStoreFile(usr, key)
+		if knownHosts, err = readKnownHostsStore(session.storeFile); err != nil {
+			return nil, err
+		}
+	}
+	if err = pee.WriteToFile(session.knownHostsFile, []byte(knownHosts), 0600); err != nil {
+		return nil, err
+	}
+
+	agentSocket := ""
+	if passphrase := credentials[sshPassphrase]; passphrase != "" {
+		agentSocket = path.Join(sshDir, "agent.sock")
+		if err = session.startAgent(agentSocket, keyFile, passphrase); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+
+	sshConfig := buildSshConfig(usr, keyFile, session.knownHostsFile, strictHostKeyChecking, agentSocket)
+	if err = pee.WriteToFile(path.Join(sshDir, "config"), []byte(sshConfig), 0600); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func buildSshConfig(usr string, keyFile string, knownHostsFile string, strictHostKeyChecking string, agentSocket string) string {
+	config := fmt.Sprintf("Host *\n\tUser %s\n\tIdentityFile %s\n\tIdentitiesOnly yes\n\tUserKnownHostsFile %s\n\tStrictHostKeyChecking %s\n",
+		usr, keyFile, knownHostsFile, strictHostKeyChecking)
+	if agentSocket != "" {
+		config += fmt.Sprintf("\tIdentityAgent %s\n", agentSocket)
+	}
+	return config
+}
+
+// startAgent starts an ssh-agent owned by the CLI user and adds the key to it, the passphrase is handed to ssh-add
+// by an askpass script which is removed right after.
+func (s *sshSession) startAgent(agentSocket string, keyFile string, passphrase string) error {
+	output, err := common.ExecuteCommand(s.pee, nil, nil, sshAgentPath, "-s", "-a", agentSocket)
+	if err != nil {
+		_, err = common.GetCommandFailureResponse(output, err, false)
+		return errors.Wrap(err, "failed starting ssh-agent")
+	}
+
+	match := sshAgentPidPattern.FindSubmatch(output)
+	if match == nil {
+		return errors.New("failed reading the pid of ssh-agent")
+	}
+	s.agentPid, _ = strconv.Atoi(string(match[1]))
+
+	sshDir := path.Dir(keyFile)
+	passphraseFile := path.Join(sshDir, "passphrase")
+	askPassFile := path.Join(sshDir, "askpass")
+	defer os.Remove(passphraseFile)
+	defer os.Remove(askPassFile)
+
+	if err = s.pee.WriteToFile(passphraseFile, []byte(passphrase), 0600); err != nil {
+		return err
+	}
+	if err = s.pee.WriteToFile(askPassFile, []byte(fmt.Sprintf("#!/bin/sh\ncat '%s'\n", passphraseFile)), 0700); err != nil {
+		return err
+	}
+
+	environment := []string{
+		"SSH_AUTH_SOCK=" + agentSocket,
+		"SSH_ASKPASS=" + askPassFile,
+		"SSH_ASKPASS_REQUIRE=force",
+		// older ssh-add versions use the askpass program only when a display is set
+		"DISPLAY=none",
+	}
+	output, err = common.ExecuteCommand(s.pee, nil, environment, sshAddPath, "-t", sshAgentKeyLifetime, keyFile)
+	if err != nil {
+		_, err = common.GetCommandFailureResponse(output, err, false)
+		return errors.Wrap(err, "failed adding the ssh key to ssh-agent, check the passphrase of the connection")
+	}
+	return nil
+}
+
+// Close keeps the host keys which were trusted on first use and stops the ssh-agent.
+func (s *sshSession) Close() {
+	if s == nil {
+		return
+	}
+
+	if s.storeFile != "" {
+		// the CLI user owns its known_hosts and could have replaced it with a symlink
+		knownHosts, err := common.ReadFileAs(s.pee, s.knownHostsFile, maxKnownHostsSize)
+		if err == nil {
+			err = mergeKnownHostsStore(s.storeFile, knownHosts)
+		}
+		if err != nil {
+			log.Errorf("failed saving trusted ssh host keys: %v", err)
+		}
+	}
+
+	if s.agentPid != 0 {
+		if err := syscall.Kill(s.agentPid, syscall.SIGTERM); err != nil {
+			log.Errorf("failed stopping ssh-agent: %v", err)
+		}
+		s.agentPid = 0
+	}
+}
+
+// knownHostsStoreFile identifies the connection by its username and key, since the same credentials
+// always reach the same hosts.
+func knownHostsStoreFile(usr string, key string) string {
+	digest := sha256.Sum256([]byte(usr + "\n" + key))
+	return path.Join(knownHostsStoreDirectory, hex.EncodeToString(digest[:]))
+}
+
+func readKnownHostsStore(storeFile string) (string, error) {
+	knownHostsStoreMutex.Lock()
+	defer knownHostsStoreMutex.Unlock()
+
+	knownHosts, err := os.ReadFile(storeFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "failed reading trusted ssh host keys")
+	}
+	return string(knownHosts), nil
+}
+
+func mergeKnownHostsStore(storeFile string, knownHosts []byte) error {
+	knownHostsStoreMutex.Lock()
+	defer knownHostsStoreMutex.Unlock()
+
+	stored, err := os.ReadFile(storeFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	merged, changed := mergeKnownHosts(stored, knownHosts)
+	if !changed {
+		return nil
+	}
+
+	if err = os.MkdirAll(path.Dir(storeFile), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(storeFile, merged, 0600)
+}
+
+// mergeKnownHosts appends the entries of added which are missing from stored, steps of the same connection
+// may run concurrently so the store is never replaced as a whole. Lines which aren't host keys are dropped.
+func mergeKnownHosts(stored []byte, added []byte) ([]byte, bool) {
+	entries := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(stored))
+	for scanner.Scan() {
+		entries[strings.TrimSpace(scanner.Text())] = true
+	}
+
+	merged := stored
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+
+	changed := false
+	scanner = bufio.NewScanner(bytes.NewReader(added))
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") || entries[entry] {
+			continue
+		}
+		if _, _, _, _, _, err := ssh.ParseKnownHosts([]byte(entry)); err != nil {
+			continue
+		}
+		entries[entry] = true
+		merged = append(merged, entry+"\n"...)
+		changed = true
+	}
+	return merged, changed
+}
+
+// sshKeyFileName returns the default ssh file name of the private key type, e.g. id_ed25519.
+func sshKeyFileName(key []byte) (string, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return "", errors.New("the ssh key is not a PEM encoded private key")
+	}
+
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		keyType, err := opensshKeyType(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		return sshKeyTypeFileName(keyType), nil
+	case "RSA PRIVATE KEY":
+		return "id_rsa", nil
+	case "EC PRIVATE KEY":
+		return "id_ecdsa", nil
+	case "DSA PRIVATE KEY":
+		return "id_dsa", nil
+	case "PRIVATE KEY":
+		parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", errors.Wrap(err, "failed parsing the ssh key")
+		}
+		switch parsedKey.(type) {
+		case *ecdsa.PrivateKey:
+			return "id_ecdsa", nil
+		case ed25519.PrivateKey:
+			return "id_ed25519", nil
+		case *rsa.PrivateKey:
+			return "id_rsa", nil
+		}
+	case "ENCRYPTED PRIVATE KEY":
+		// the key type of encrypted PKCS#8 keys is only known after decrypting them, ssh reads any type from any file
+		return "id_rsa", nil
+	}
+	return "", errors.Errorf("unsupported ssh key type: %s", block.Type)
+}
+
+func sshKeyTypeFileName(keyType string) string {
+	switch {
+	case keyType == "ssh-ed25519":
+		return "id_ed25519"
+	case keyType == "sk-ssh-ed25519@openssh.com":
+		return "id_ed25519_sk"
+	case strings.HasPrefix(keyType, "ecdsa-sha2-"):
+		return "id_ecdsa"
+	case keyType == "sk-ecdsa-sha2-nistp256@openssh.com":
+		return "id_ecdsa_sk"
+	case keyType == "ssh-dss":
+		return "id_dsa"
+	default:
+		return "id_rsa"
+	}
+}
+
+// opensshKeyType reads the key type from the public key of an openssh private key, which is not encrypted
+// even when the key has a passphrase.
+func opensshKeyType(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte(opensshKeyMagic)) {
+		return "", errors.New("invalid openssh private key")
+	}
+	data = data[len(opensshKeyMagic):]
+
+	// cipher name, kdf name and kdf options precede the number of keys and the first public key
+	for i := 0; i < 3; i++ {
+		var err error
+		if _, data, err = readSshString(data); err != nil {
+			return "", err
+		}
+	}
+	if len(data) < 4 {
+		return "", errors.New("invalid openssh private key")
+	}
+	publicKey, _, err := readSshString(data[4:])
+	if err != nil {
+		return "", err
+	}
+	keyType, _, err := readSshString(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(keyType), nil
+}
+
+func readSshString(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("invalid openssh private key")
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint64(len(data)-4) < uint64(length) {
+		return nil, nil, errors.New("invalid openssh private key")
+	}
+	return data[4 : 4+length], data[4+length:], nil
+}
@@ -0,0 +1,418 @@
+package implementation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	hostsParameterName    = "Hosts"
+	scriptParameterName   = "Script"
+	jumpHostParameterName = "Jump Host"
+	timeoutParameterName  = "Timeout"
+
+	defaultSshPort    = "22"
+	defaultSshTimeout = 60 * time.Second
+	maxSshTimeout     = 24 * time.Hour
+
+	// the script is uploaded through stdin so that its shebang picks the interpreter
+	sshScriptCommand = `f=$(mktemp) && cat > "$f" && chmod 700 "$f" && { "$f"; rc=$?; rm -f "$f"; exit $rc; }`
+)
+
+// sshHostResult is the result of running the command of a step on a single host.
+type sshHostResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	Error    string `json:"error,omitempty"`
+}
+
+// sshRemoteCommand is what runs on every host of the step.
+type sshRemoteCommand struct {
+	Command  string
+	Stdin    string
+	JumpHost string
+	Timeout  time.Duration
+}
+
+func executeCoreSshAction(_ *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	credentials, err := ctx.GetCredentials("ssh")
+	if err != nil || credentials == nil {
+		return nil, errors.New("the ssh action requires an ssh connection")
+	}
+
+	results, err := runSshFanOut(credentials, request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+
+	// the step fails only when the command failed on every host
+	for _, result := range results {
+		if result.Error == "" {
+			return response, nil
+		}
+	}
+	return response, common.CLIError
+}
+
+func runSshFanOut(credentials map[string]string, request *plugin.ExecuteActionRequest) (map[string]sshHostResult, error) {
+	hosts, err := parseSshHosts(request.Parameters[hostsParameterName])
+	if err != nil {
+		return nil, err
+	}
+
+	remoteCommand, err := buildSshRemoteCommand(request)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency, err := getFanOutConcurrency(request)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := newSshClientConfig(credentials, remoteCommand.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]sshHostResult, len(hosts))
+	var resultsMutex sync.Mutex
+
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result := runSshCommand(config, host, remoteCommand)
+			result.Stdout = string(common.RedactSecrets(request, []byte(result.Stdout)))
+			result.Stderr = string(common.RedactSecrets(request, []byte(result.Stderr)))
+
+			resultsMutex.Lock()
+			results[host] = result
+			resultsMutex.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// parseSshHosts reads the comma or newline separated hosts of the step, each of them may have a port.
+func parseSshHosts(rawHosts string) ([]string, error) {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, host := range strings.FieldsFunc(rawHosts, func(r rune) bool { return r == ',' || r == '\n' }) {
+		host = strings.TrimSpace(host)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts were provided")
+	}
+	return hosts, nil
+}
+
+func sshAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultSshPort)
+}
+
+func buildSshRemoteCommand(request *plugin.ExecuteActionRequest) (sshRemoteCommand, error) {
+	remoteCommand := sshRemoteCommand{
+		Command:  request.Parameters[commandParameterName],
+		JumpHost: strings.TrimSpace(request.Parameters[jumpHostParameterName]),
+		Timeout:  defaultSshTimeout,
+	}
+
+	script := request.Parameters[scriptParameterName]
+	switch {
+	case remoteCommand.Command != "" && script != "":
+		return sshRemoteCommand{}, errors.New("either a command or a script can be provided, not both")
+	case script != "":
+		remoteCommand.Command = sshScriptCommand
+		remoteCommand.Stdin = script
+	case remoteCommand.Command == "":
+		return sshRemoteCommand{}, errors.New("a command or a script is required")
+	}
+
+	if rawTimeout := request.Parameters[timeoutParameterName]; rawTimeout != "" {
+		seconds, err := strconv.Atoi(rawTimeout)
+		timeout := time.Duration(seconds) * time.Second
+		if err != nil || timeout <= 0 || timeout > maxSshTimeout {
+			return sshRemoteCommand{}, errors.Errorf("timeout must be between 1 and %d seconds", int(maxSshTimeout.Seconds()))
+		}
+		remoteCommand.Timeout = timeout
+	}
+	return remoteCommand, nil
+}
+
+// newSshClientConfig uses the same credentials as the ssh setup of git: the key of the connection, decrypted in
+// memory when it has a passphrase, and the known_hosts of the connection or the host keys trusted on first use.
+func newSshClientConfig(credentials map[string]string, timeout time.Duration) (*ssh.ClientConfig, error) {
+	key := credentials[sshKey]
+	if key == "" {
+		return nil, errors.New("missing ssh key")
+	}
+
+	usr := credentials[sshUsername]
+	if usr == "" {
+		return nil, errors.New("missing ssh username")
+	}
+
+	var signer ssh.Signer
+	var err error
+	if passphrase := credentials[sshPassphrase]; passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(key))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing the ssh key")
+	}
+
+	hostKeyCallback, err := newSshHostKeyCallback(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            usr,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+func newSshHostKeyCallback(credentials map[string]string) (ssh.HostKeyCallback, error) {
+	knownHosts := credentials[sshKnownHosts]
+	if strings.TrimSpace(knownHosts) == "" {
+		storeFile := knownHostsStoreFile(credentials[sshUsername], credentials[sshKey])
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return trustSshHostKeyOnFirstUse(storeFile, hostname, remote, key)
+		}, nil
+	}
+
+	// knownhosts only reads files, which it parses right away
+	knownHostsFile, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(knownHostsFile.Name())
+	defer knownHostsFile.Close()
+
+	if _, err = knownHostsFile.WriteString(knownHosts); err != nil {
+		return nil, err
+	}
+
+	callback, err := knownhosts.New(knownHostsFile.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing the known_hosts of the connection")
+	}
+	return callback, nil
+}
+
+// trustSshHostKeyOnFirstUse accepts and keeps the key of a host which is not in the store yet,
+// a host whose key changed is rejected.
+func trustSshHostKeyOnFirstUse(storeFile string, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsStoreMutex.Lock()
+	defer knownHostsStoreMutex.Unlock()
+
+	stored, err := os.ReadFile(storeFile)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed reading trusted ssh host keys")
+	}
+
+	if len(stored) > 0 {
+		callback, err := knownhosts.New(storeFile)
+		if err != nil {
+			return errors.Wrap(err, "failed parsing trusted ssh host keys")
+		}
+
+		err = callback(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); err == nil || !ok || len(keyErr.Want) > 0 {
+			return err
+		}
+	}
+
+	merged, _ := mergeKnownHosts(stored, []byte(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)))
+	if err = os.MkdirAll(knownHostsStoreDirectory, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(storeFile, merged, 0600)
+}
+
+// sshConnections closes the connections to a host at once, including the ones which are still being set up,
+// so a timeout also interrupts a connect or a handshake which stalls.
+type sshConnections struct {
+	mutex   sync.Mutex
+	closed  bool
+	closers []func()
+}
+
+// add keeps the connection for Close, it's closed right away and false is returned when Close was already called.
+func (c *sshConnections) add(closer func()) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		closer()
+		return false
+	}
+	c.closers = append(c.closers, closer)
+	return true
+}
+
+func (c *sshConnections) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	// the tunnel goes before the jump host it runs through
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		c.closers[i]()
+	}
+}
+
+// dialSsh connects to the host, through the jump host when there is one. Every connection is added to connections
+// as soon as it's open, closing them interrupts the dial.
+func dialSsh(config *ssh.ClientConfig, jumpHost string, host string, connections *sshConnections) (*ssh.Client, error) {
+	if jumpHost == "" {
+		conn, err := net.DialTimeout("tcp", sshAddress(host), config.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed connecting")
+		}
+		client, err := newSshClient(conn, sshAddress(host), config, connections)
+		return client, errors.Wrap(err, "failed connecting")
+	}
+
+	jumpConn, err := net.DialTimeout("tcp", sshAddress(jumpHost), config.Timeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connecting to the jump host")
+	}
+	jumpClient, err := newSshClient(jumpConn, sshAddress(jumpHost), config, connections)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connecting to the jump host")
+	}
+
+	conn, err := jumpClient.Dial("tcp", sshAddress(host))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connecting through the jump host")
+	}
+	client, err := newSshClient(conn, sshAddress(host), config, connections)
+	return client, errors.Wrap(err, "failed connecting")
+}
+
+// newSshClient runs the ssh handshake on a connection which is already open.
+func newSshClient(conn net.Conn, address string, config *ssh.ClientConfig, connections *sshConnections) (*ssh.Client, error) {
+	if !connections.add(func() { conn.Close() }) {
+		return nil, errors.New("connection closed")
+	}
+
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client := ssh.NewClient(clientConn, channels, requests)
+	if !connections.add(func() { client.Close() }) {
+		return nil, errors.New("connection closed")
+	}
+	return client, nil
+}
+
+func runSshCommand(config *ssh.ClientConfig, host string, remoteCommand sshRemoteCommand) sshHostResult {
+	result := sshHostResult{ExitCode: -1}
+
+	connections := &sshConnections{}
+	defer connections.Close()
+
+	var timedOutMutex sync.Mutex
+	timedOut := false
+	// the timeout covers connecting as well as running the command
+	timer := time.AfterFunc(remoteCommand.Timeout, func() {
+		timedOutMutex.Lock()
+		timedOut = true
+		timedOutMutex.Unlock()
+		connections.Close()
+	})
+	defer timer.Stop()
+	hasTimedOut := func() bool {
+		timedOutMutex.Lock()
+		defer timedOutMutex.Unlock()
+		return timedOut
+	}
+
+	client, err := dialSsh(config, remoteCommand.JumpHost, host, connections)
+	if err != nil {
+		if hasTimedOut() {
+			result.Error = fmt.Sprintf("timed out after %s", remoteCommand.Timeout)
+		} else {
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		result.Error = errors.Wrap(err, "failed opening an ssh session").Error()
+		return result
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if remoteCommand.Stdin != "" {
+		session.Stdin = strings.NewReader(remoteCommand.Stdin)
+	}
+
+	err = session.Run(remoteCommand.Command)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	exitErr, isExitErr := err.(*ssh.ExitError)
+	switch {
+	case hasTimedOut():
+		result.Error = fmt.Sprintf("timed out after %s", remoteCommand.Timeout)
+	case err == nil:
+		result.ExitCode = 0
+	case isExitErr:
+		result.ExitCode = exitErr.ExitStatus()
+		result.Error = err.Error()
+	default:
+		result.Error = err.Error()
+	}
+	return result
+}
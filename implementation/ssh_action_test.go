@@ -0,0 +1,362 @@
+package implementation
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSshServer stands in for a remote host: it echoes the commands it runs, exits with 3 for commands
+// containing "fail", never finishes "sleep" and forwards tcp connections like a jump host.
+type testSshServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+}
+
+func newTestSshSigner(t *testing.T) ssh.Signer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.Nil(t, err)
+	return signer
+}
+
+func startTestSshServer(t *testing.T, authorizedKey ssh.PublicKey) *testSshServer {
+	server := &testSshServer{hostKey: newTestSshSigner(t)}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "deploy" && string(key.Marshal()) == string(authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized")
+		},
+	}
+	config.AddHostKey(server.hostKey)
+
+	var err error
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { server.listener.Close() })
+
+	go func() {
+		for {
+			conn, err := server.listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s *testSshServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testSshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.serveSession(newChannel)
+		case "direct-tcpip":
+			go s.serveForward(newChannel)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+		}
+	}
+}
+
+func (s *testSshServer) serveSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for request := range requests {
+		if request.Type != "exec" {
+			_ = request.Reply(false, nil)
+			continue
+		}
+
+		var exec struct{ Command string }
+		if err = ssh.Unmarshal(request.Payload, &exec); err != nil {
+			_ = request.Reply(false, nil)
+			return
+		}
+		_ = request.Reply(true, nil)
+
+		status := uint32(0)
+		switch {
+		case exec.Command == "sleep":
+			// until the client goes away
+			for range requests {
+			}
+			return
+		case exec.Command == sshScriptCommand:
+			script, _ := ioutil.ReadAll(channel)
+			_, _ = fmt.Fprintf(channel, "script: %s", script)
+		case strings.Contains(exec.Command, "fail"):
+			_, _ = fmt.Fprint(channel.Stderr(), "failed")
+			status = 3
+		default:
+			_, _ = fmt.Fprintf(channel, "ran: %s", exec.Command)
+		}
+
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func (s *testSshServer) serveForward(newChannel ssh.NewChannel) {
+	var forward struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &forward); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(forward.Host, fmt.Sprint(forward.Port)))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		conn.Close()
+	}()
+	_, _ = io.Copy(channel, conn)
+	channel.Close()
+}
+
+func newTestSshCredentials(t *testing.T) (map[string]string, ssh.PublicKey) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.Nil(t, err)
+
+	credentials := map[string]string{
+		sshUsername: "deploy",
+		sshKey:      string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+	return credentials, signer.PublicKey()
+}
+
+func useTestKnownHostsStore(t *testing.T) {
+	defaultStoreDirectory := knownHostsStoreDirectory
+	knownHostsStoreDirectory = path.Join(t.TempDir(), "known_hosts")
+	t.Cleanup(func() { knownHostsStoreDirectory = defaultStoreDirectory })
+}
+
+func closedTcpAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func TestRunSshFanOut(t *testing.T) {
+	useTestKnownHostsStore(t)
+	credentials, publicKey := newTestSshCredentials(t)
+	server := startTestSshServer(t, publicKey)
+	jumpServer := startTestSshServer(t, publicKey)
+	unreachable := closedTcpAddress(t)
+
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   map[string]sshHostResult
+		wantErr    string
+	}{
+		{
+			name:       "command on several hosts",
+			parameters: map[string]string{hostsParameterName: server.Address() + ",\n" + unreachable, commandParameterName: "uptime"},
+			expected: map[string]sshHostResult{
+				server.Address(): {ExitCode: 0, Stdout: "ran: uptime"},
+				unreachable:      {ExitCode: -1, Error: "failed connecting"},
+			},
+		},
+		{
+			name:       "script",
+			parameters: map[string]string{hostsParameterName: server.Address(), scriptParameterName: "#!/bin/sh\necho hello\n"},
+			expected:   map[string]sshHostResult{server.Address(): {ExitCode: 0, Stdout: "script: #!/bin/sh\necho hello\n"}},
+		},
+		{
+			name:       "failing command",
+			parameters: map[string]string{hostsParameterName: server.Address(), commandParameterName: "fail now"},
+			expected:   map[string]sshHostResult{server.Address(): {ExitCode: 3, Stderr: "failed", Error: "Process exited with status 3"}},
+		},
+		{
+			name:       "jump host",
+			parameters: map[string]string{hostsParameterName: server.Address(), jumpHostParameterName: jumpServer.Address(), commandParameterName: "hostname"},
+			expected:   map[string]sshHostResult{server.Address(): {ExitCode: 0, Stdout: "ran: hostname"}},
+		},
+		{
+			name:       "timeout",
+			parameters: map[string]string{hostsParameterName: server.Address(), commandParameterName: "sleep", timeoutParameterName: "1"},
+			expected:   map[string]sshHostResult{server.Address(): {ExitCode: -1, Error: "timed out after 1s"}},
+		},
+		{
+			name:       "command and script",
+			parameters: map[string]string{hostsParameterName: server.Address(), commandParameterName: "uptime", scriptParameterName: "uptime"},
+			wantErr:    "either a command or a script can be provided",
+		},
+		{
+			name:       "no hosts",
+			parameters: map[string]string{hostsParameterName: " , ", commandParameterName: "uptime"},
+			wantErr:    "no hosts were provided",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("test runSshFanOut(): "+tt.name, func(t *testing.T) {
+			results, err := runSshFanOut(credentials, &plugin.ExecuteActionRequest{Parameters: tt.parameters})
+			if tt.wantErr != "" {
+				require.NotNil(t, err, tt.name)
+				assert.Contains(t, err.Error(), tt.wantErr, tt.name)
+				return
+			}
+			require.Nil(t, err, tt.name)
+			require.Len(t, results, len(tt.expected))
+
+			for host, expected := range tt.expected {
+				result := results[host]
+				assert.Equal(t, expected.ExitCode, result.ExitCode, host)
+				assert.Equal(t, expected.Stdout, result.Stdout, host)
+				assert.Equal(t, expected.Stderr, result.Stderr, host)
+				if expected.Error == "" {
+					assert.Empty(t, result.Error, host)
+				} else {
+					assert.Contains(t, result.Error, expected.Error, host)
+				}
+			}
+		})
+	}
+}
+
+func TestSshHostKeyVerification(t *testing.T) {
+	useTestKnownHostsStore(t)
+	credentials, publicKey := newTestSshCredentials(t)
+	server := startTestSshServer(t, publicKey)
+	remoteCommand := sshRemoteCommand{Command: "uptime", Timeout: defaultSshTimeout}
+
+	// trusted on first use, then a different key of the same host is rejected
+	config, err := newSshClientConfig(credentials, defaultSshTimeout)
+	require.Nil(t, err)
+	assert.Empty(t, runSshCommand(config, server.Address(), remoteCommand).Error)
+	assert.Empty(t, runSshCommand(config, server.Address(), remoteCommand).Error)
+
+	otherHostKey := newTestSshSigner(t).PublicKey()
+	err = config.HostKeyCallback(server.Address(), server.listener.Addr(), otherHostKey)
+	assert.NotNil(t, err)
+
+	// the known_hosts of the connection is used as is
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.Address())}, server.hostKey.PublicKey())
+	credentials[sshKnownHosts] = line + "\n"
+	config, err = newSshClientConfig(credentials, defaultSshTimeout)
+	require.Nil(t, err)
+	assert.Empty(t, runSshCommand(config, server.Address(), remoteCommand).Error)
+
+	credentials[sshKnownHosts] = knownhosts.Line([]string{knownhosts.Normalize(server.Address())}, otherHostKey) + "\n"
+	config, err = newSshClientConfig(credentials, defaultSshTimeout)
+	require.Nil(t, err)
+	assert.Contains(t, runSshCommand(config, server.Address(), remoteCommand).Error, "key mismatch")
+}
+
+func TestNewSshClientConfigWithPassphrase(t *testing.T) {
+	useTestKnownHostsStore(t)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	// legacy encrypted PEM keys are still common in ssh connections
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privateKey), []byte("secret"), x509.PEMCipherAES256)
+	require.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.Nil(t, err)
+	server := startTestSshServer(t, signer.PublicKey())
+
+	credentials := map[string]string{
+		sshUsername:   "deploy",
+		sshKey:        string(pem.EncodeToMemory(block)),
+		sshPassphrase: "secret",
+	}
+	config, err := newSshClientConfig(credentials, defaultSshTimeout)
+	require.Nil(t, err)
+	result := runSshCommand(config, server.Address(), sshRemoteCommand{Command: "uptime", Timeout: defaultSshTimeout})
+	assert.Empty(t, result.Error)
+	assert.Equal(t, "ran: uptime", result.Stdout)
+
+	credentials[sshPassphrase] = "wrong"
+	_, err = newSshClientConfig(credentials, defaultSshTimeout)
+	assert.NotNil(t, err)
+}
+
+func TestSshHandshakeTimeout(t *testing.T) {
+	useTestKnownHostsStore(t)
+	credentials, _ := newTestSshCredentials(t)
+
+	// accepts the connection but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config, err := newSshClientConfig(credentials, defaultSshTimeout)
+	require.Nil(t, err)
+
+	started := time.Now()
+	result := runSshCommand(config, listener.Addr().String(), sshRemoteCommand{Command: "uptime", Timeout: 200 * time.Millisecond})
+	assert.Contains(t, result.Error, "timed out after 200ms")
+	assert.Less(t, int64(time.Since(started)), int64(defaultSshTimeout))
+
+	result = runSshCommand(config, "127.0.0.1:1", sshRemoteCommand{Command: "uptime", JumpHost: listener.Addr().String(), Timeout: 200 * time.Millisecond})
+	assert.Contains(t, result.Error, "timed out after 200ms")
+}
@@ -0,0 +1,144 @@
+package implementation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sshString(value []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	return append(length, value...)
+}
+
+func opensshPrivateKey(keyType string, cipher string) []byte {
+	publicKey := append(sshString([]byte(keyType)), sshString(make([]byte, 32))...)
+
+	data := []byte(opensshKeyMagic)
+	data = append(data, sshString([]byte(cipher))...)
+	data = append(data, sshString([]byte("bcrypt"))...)
+	data = append(data, sshString([]byte("salt"))...)
+	data = append(data, 0, 0, 0, 1)
+	data = append(data, sshString(publicKey)...)
+	data = append(data, sshString([]byte("encrypted private key"))...)
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: data})
+}
+
+func TestSshKeyFileName(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.Nil(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	ecdsaDer, err := x509.MarshalECPrivateKey(ecdsaKey)
+	require.Nil(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name     string
+		key      []byte
+		expected string
+		wantErr  bool
+	}{
+		{name: "openssh ed25519 with a passphrase", key: opensshPrivateKey("ssh-ed25519", "aes256-ctr"), expected: "id_ed25519"},
+		{name: "openssh ecdsa", key: opensshPrivateKey("ecdsa-sha2-nistp384", "none"), expected: "id_ecdsa"},
+		{name: "openssh security key", key: opensshPrivateKey("sk-ssh-ed25519@openssh.com", "none"), expected: "id_ed25519_sk"},
+		{name: "openssh rsa", key: opensshPrivateKey("ssh-rsa", "none"), expected: "id_rsa"},
+		{name: "pkcs1 rsa", key: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), expected: "id_rsa"},
+		{name: "sec1 ecdsa", key: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecdsaDer}), expected: "id_ecdsa"},
+		{name: "pkcs8 ed25519", key: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Der}), expected: "id_ed25519"},
+		{name: "truncated openssh key", key: pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: []byte(opensshKeyMagic + "\x00\x00")}), wantErr: true},
+		{name: "not a key", key: []byte("ssh-ed25519 AAAA user@host"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("test sshKeyFileName(): "+tt.name, func(t *testing.T) {
+			fileName, err := sshKeyFileName(tt.key)
+			if tt.wantErr {
+				assert.NotNil(t, err, tt.name)
+				return
+			}
+			require.Nil(t, err, tt.name)
+			assert.Equal(t, tt.expected, fileName)
+		})
+	}
+}
+
+func TestBuildSshConfig(t *testing.T) {
+	assert.Equal(t,
+		"Host *\n\tUser git\n\tIdentityFile /home/git/.ssh/id_ed25519\n\tIdentitiesOnly yes\n\tUserKnownHostsFile /home/git/.ssh/known_hosts\n\tStrictHostKeyChecking yes\n",
+		buildSshConfig("git", "/home/git/.ssh/id_ed25519", "/home/git/.ssh/known_hosts", "yes", ""))
+
+	assert.Contains(t,
+		buildSshConfig("git", "/home/git/.ssh/id_rsa", "/home/git/.ssh/known_hosts", "accept-new", "/home/git/.ssh/agent.sock"),
+		"\tStrictHostKeyChecking accept-new\n\tIdentityAgent /home/git/.ssh/agent.sock\n")
+}
+
+const (
+	githubKnownHost = "github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	gitlabKnownHost = "gitlab.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAfuCHKVTjquxvt6CM6tdG4SLp1Btn/nOeHHE5UOzRdf"
+)
+
+func TestMergeKnownHosts(t *testing.T) {
+	merged, changed := mergeKnownHosts([]byte(githubKnownHost), []byte(githubKnownHost+"\n# comment\n\n"+gitlabKnownHost+"\nroot:x:0:0:root:/root:/bin/bash\n"))
+	assert.True(t, changed)
+	assert.Equal(t, githubKnownHost+"\n"+gitlabKnownHost+"\n", string(merged))
+
+	_, changed = mergeKnownHosts(merged, []byte(gitlabKnownHost+"\n"))
+	assert.False(t, changed)
+
+	_, changed = mergeKnownHosts(merged, []byte("gitlab.com ssh-rsa BBBB\n"))
+	assert.False(t, changed)
+}
+
+func TestKnownHostsStore(t *testing.T) {
+	defaultStoreDirectory := knownHostsStoreDirectory
+	knownHostsStoreDirectory = path.Join(t.TempDir(), "known_hosts")
+	defer func() { knownHostsStoreDirectory = defaultStoreDirectory }()
+
+	storeFile := knownHostsStoreFile("git", "key")
+	assert.NotEqual(t, storeFile, knownHostsStoreFile("git", "other key"))
+
+	knownHosts, err := readKnownHostsStore(storeFile)
+	require.Nil(t, err)
+	assert.Empty(t, knownHosts)
+
+	env := currentUserEnvironment{home: t.TempDir()}
+	sessionKnownHosts := path.Join(env.home, "known_hosts")
+	require.Nil(t, os.WriteFile(sessionKnownHosts, []byte(githubKnownHost+"\n"), 0600))
+	sessionKnownHostsContent, err := common.ReadFileAs(env, sessionKnownHosts, maxKnownHostsSize)
+	require.Nil(t, err)
+	require.Nil(t, mergeKnownHostsStore(storeFile, sessionKnownHostsContent))
+
+	knownHosts, err = readKnownHostsStore(storeFile)
+	require.Nil(t, err)
+	assert.Equal(t, githubKnownHost+"\n", knownHosts)
+}
+
+func TestReadKnownHostsTooLarge(t *testing.T) {
+	env := currentUserEnvironment{home: t.TempDir()}
+	knownHostsFile := path.Join(env.home, "known_hosts")
+	require.Nil(t, os.WriteFile(knownHostsFile, bytes.Repeat([]byte("#\n"), maxKnownHostsSize), 0600))
+
+	_, err := common.ReadFileAs(env, knownHostsFile, maxKnownHostsSize)
+	assert.NotNil(t, err)
+
+	_, err = common.ReadFileAs(env, path.Join(env.home, "missing"), maxKnownHostsSize)
+	assert.NotNil(t, err)
+}
@@ -3,9 +3,10 @@ package implementation
 import "github.com/blinkops/blink-sdk/plugin/connections"
 
 type RunnerCodeStructure struct {
-	Code        string                                     `json:"code"`
-	Context     map[string]interface{}                     `json:"context"`
-	Connections map[string]*connections.ConnectionInstance `json:"connections"`
+	Code                string                                     `json:"code"`
+	Context             map[string]interface{}                     `json:"context"`
+	Connections         map[string]*connections.ConnectionInstance `json:"connections"`
+	ResolvedConnections map[string]map[string]string               `json:"resolved_connections"`
 }
 
 type RunnerCodeResponse struct {
@@ -14,3 +15,9 @@ type RunnerCodeResponse struct {
 	Output  string                 `json:"output"`
 	Error   string                 `json:"error"`
 }
+
+// RunnerCodeOutput is returned instead of the raw output when the caller asks to get the log as well.
+type RunnerCodeOutput struct {
+	Output string `json:"output"`
+	Log    string `json:"log"`
+}
@@ -0,0 +1,381 @@
+package implementation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	terraformModeParameterName      = "Mode"
+	repositoryParameterName         = "Repository"
+	refParameterName                = "Ref"
+	workingDirectoryParameterName   = "Working Directory"
+	backendConfigParameterName      = "Backend Config"
+	workspaceParameterName          = "Workspace"
+	terraformVariablesParameterName = "Variables"
+
+	terraformModeCommand = "command"
+	terraformModePlan    = "plan"
+	terraformModeApply   = "apply"
+
+	defaultTerraformWorkspace = "default"
+	terraformPlanFile         = "tfplan"
+	terraformVariablesFile    = "variables.tfvars.json"
+)
+
+type terraformPlanSummary struct {
+	Workspace       string                    `json:"workspace"`
+	PlanFile        string                    `json:"plan_file"`
+	Summary         terraformChangeCounts     `json:"summary"`
+	ResourceChanges []terraformResourceChange `json:"resource_changes"`
+	Diagnostics     []terraformDiagnostic     `json:"diagnostics,omitempty"`
+	Output          string                    `json:"output,omitempty"`
+}
+
+type terraformChangeCounts struct {
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+}
+
+type terraformResourceChange struct {
+	Address string   `json:"address"`
+	Actions []string `json:"actions"`
+}
+
+// the parts of `terraform show -json <plan>` which are summarized
+type terraformShowPlan struct {
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Change  struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// terraformWorkspace is a working directory in the session home, so the sources, the local state and the saved
+// plan outlive the CLI user of a single step and a later apply step of the execution can pick them up.
+type terraformWorkspace struct {
+	Name             string
+	Directory        string
+	WorkingDirectory string
+}
+
+func (w terraformWorkspace) planFile() string {
+	return path.Join(w.Directory, terraformPlanFile)
+}
+
+func executeCoreTerraFormStructuredAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, mode string) ([]byte, error) {
+	workspace, err := prepareTerraformWorkspace(e, ctx, request, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// a plan step itself doesn't change anything, the deletions of an apply are counted once terraform is set up
+	if mode == terraformModeApply {
+		if _, err = os.Stat(workspace.planFile()); err != nil {
+			return nil, errors.Errorf("no saved plan found for workspace %s, run the terraform action in plan mode first", workspace.Name)
+		}
+	} else if err = enforcePolicies(request, "terraform "+mode, 0); err != nil {
+		return nil, err
+	}
+
+	ce, err := initTerraformCliUser(e, ctx, request)
+	if ce != nil {
+		defer e.CleanupCliUser(ce.GetUserName())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The CLI user owns the workspace while terraform runs, afterwards it's handed back to the session.
+	if err = ce.ChownTree(workspace.Directory); err != nil {
+		return nil, errors.Wrap(err, "failed handing the terraform workspace to the cli user")
+	}
+	defer func() {
+		if err := e.ChownTree(workspace.Directory); err != nil {
+			log.Errorf("failed handing the terraform workspace back to the session: %v", err)
+		}
+	}()
+
+	environment := []string{fmt.Sprintf("TERRAFORM_USER=%s", ce.GetUserName())}
+
+	if output, err := common.ExecuteBash(e, request, environment, buildTerraformInitCommand(workspace, request.Parameters[backendConfigParameterName])); err != nil {
+		return common.GetCommandFailureResponse(output, err, true)
+	}
+
+	if mode == terraformModeApply {
+		deletions, err := countTerraformPlanDeletions(e, request, environment, workspace)
+		if err != nil {
+			return nil, err
+		}
+		if err = enforcePolicies(request, "terraform "+mode, deletions); err != nil {
+			return nil, err
+		}
+		return applyTerraformPlan(e, request, environment, workspace)
+	}
+	return planTerraformWorkspace(e, ce, request, environment, workspace)
+}
+
+func planTerraformWorkspace(e *execution.PrivateExecutionEnvironment, ce *execution.PrivateExecutionEnvironment, request *plugin.ExecuteActionRequest, environment []string, workspace terraformWorkspace) ([]byte, error) {
+	planArgs := []string{"terraform", "-chdir=" + workspace.WorkingDirectory, "plan", "-input=false", "-no-color", "-json", "-out=" + workspace.planFile()}
+
+	if variables := request.Parameters[terraformVariablesParameterName]; variables != "" {
+		if !json.Valid([]byte(variables)) {
+			return nil, errors.New("terraform variables must be a json object")
+		}
+
+		variablesFile := path.Join(workspace.Directory, terraformVariablesFile)
+		// terraform runs as the CLI user, which has to be able to read the variables
+		if err := ce.WriteToFile(variablesFile, []byte(variables), 0600); err != nil {
+			return nil, errors.Wrap(err, "failed writing the terraform variables")
+		}
+		planArgs = append(planArgs, "-var-file="+variablesFile)
+	}
+
+	planOutput, err := common.ExecuteBash(e, request, environment, quoteCommand(planArgs))
+	if err != nil {
+		return common.GetCommandFailureResponse(formatTerraformFailure(planOutput), err, true)
+	}
+
+	summary, err := showTerraformPlan(e, request, environment, workspace)
+	if err != nil {
+		return nil, err
+	}
+	summary.Workspace = workspace.Name
+	summary.PlanFile = workspace.planFile()
+
+	if planResult, ok := parseTerraformJSONOutput(planOutput); ok {
+		summary.Diagnostics = planResult.Diagnostics
+		summary.Output = planResult.Summary
+	} else {
+		summary.Output = string(planOutput)
+	}
+	return json.Marshal(summary)
+}
+
+// showTerraformPlan summarizes the saved plan with terraform show, run by the terraform CLI user.
+func showTerraformPlan(e *execution.PrivateExecutionEnvironment, request *plugin.ExecuteActionRequest, environment []string, workspace terraformWorkspace) (terraformPlanSummary, error) {
+	showArgs := []string{"terraform", "-chdir=" + workspace.WorkingDirectory, "show", "-json", workspace.planFile()}
+	showOutput, err := common.ExecuteBash(e, request, environment, quoteCommand(showArgs))
+	if err != nil {
+		_, err = common.GetCommandFailureResponse(showOutput, err, false)
+		return terraformPlanSummary{}, errors.Wrap(err, "failed reading the saved plan")
+	}
+	return summarizeTerraformPlan(showOutput)
+}
+
+// countTerraformPlanDeletions counts the deletions of the plan which is about to be applied. They are read from
+// the plan itself, since the session can change any file it left in the workspace.
+func countTerraformPlanDeletions(e *execution.PrivateExecutionEnvironment, request *plugin.ExecuteActionRequest, environment []string, workspace terraformWorkspace) (int, error) {
+	summary, err := showTerraformPlan(e, request, environment, workspace)
+	if err != nil {
+		return 0, err
+	}
+	return summary.Summary.Destroy, nil
+}
+
+func applyTerraformPlan(e *execution.PrivateExecutionEnvironment, request *plugin.ExecuteActionRequest, environment []string, workspace terraformWorkspace) ([]byte, error) {
+	applyArgs := []string{"terraform", "-chdir=" + workspace.WorkingDirectory, "apply", "-input=false", "-no-color", "-json", workspace.planFile()}
+	output, err := common.ExecuteBash(e, request, environment, quoteCommand(applyArgs))
+
+	// a saved plan can only be applied once
+	_ = os.Remove(workspace.planFile())
+
+	if err != nil {
+		return common.GetCommandFailureResponse(formatTerraformFailure(output), err, true)
+	}
+	return formatTerraformOutput(output), nil
+}
+
+// prepareTerraformWorkspace resolves the workspace directory of the step. In plan mode the repository (when given)
+// is cloned into it, the working directory is relative to the clone or otherwise to the session home.
+func prepareTerraformWorkspace(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, mode string) (terraformWorkspace, error) {
+	workspace := terraformWorkspace{Name: request.Parameters[workspaceParameterName]}
+	if workspace.Name == "" {
+		workspace.Name = defaultTerraformWorkspace
+	}
+	if strings.ContainsAny(workspace.Name, "/\\") || workspace.Name == "." || workspace.Name == ".." {
+		return workspace, errors.Errorf("invalid terraform workspace name: %s", workspace.Name)
+	}
+
+	workspace.Directory = path.Join(e.GetHomeDirectory(), "terraform", workspace.Name)
+	if err := e.CreateDirectory(workspace.Directory); err != nil {
+		return workspace, errors.Wrap(err, "failed creating the terraform workspace directory")
+	}
+
+	sourceRoot := e.GetHomeDirectory()
+	if repository := request.Parameters[repositoryParameterName]; repository != "" {
+		sourceRoot = path.Join(workspace.Directory, "source")
+		if mode == terraformModePlan {
+			if err := cloneTerraformSource(e, ctx, request, repository, request.Parameters[refParameterName], workspace.Directory, sourceRoot); err != nil {
+				return workspace, err
+			}
+		}
+	}
+
+	workspace.WorkingDirectory = filepath.Join(sourceRoot, filepath.Clean("/"+request.Parameters[workingDirectoryParameterName]))
+	if _, err := os.Stat(workspace.WorkingDirectory); err != nil {
+		return workspace, errors.Errorf("terraform working directory %s doesn't exist", request.Parameters[workingDirectoryParameterName])
+	}
+
+	return workspace, nil
+}
+
+// cloneTerraformSource clones the sources as a git CLI user with the github, gitlab or ssh connection of the step,
+// so that private repositories and modules can be planned.
+func cloneTerraformSource(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest, repository string, ref string, workspaceDirectory string, destination string) error {
+	// every plan starts from a fresh checkout, the state lives in the workspace directory and not in the clone
+	if err := os.RemoveAll(destination); err != nil {
+		return errors.Wrap(err, "failed removing the previous terraform sources")
+	}
+
+	cliUser, err := e.CreateCliUser("git")
+	if err != nil {
+		return errors.Wrap(err, "failed to create cli user")
+	}
+	defer e.CleanupCliUser(cliUser.Username)
+	ce := e.CreateCliUserPee(cliUser)
+
+	credentials, err := initGitCredentials(e, ce, ctx)
+	if err != nil {
+		return err
+	}
+	defer credentials.Close()
+
+	if err = ce.ChownTree(workspaceDirectory); err != nil {
+		return errors.Wrap(err, "failed handing the terraform workspace to the git cli user")
+	}
+	defer func() {
+		if err := e.ChownTree(workspaceDirectory); err != nil {
+			log.Errorf("failed handing the terraform workspace back to the session: %v", err)
+		}
+	}()
+
+	args := []string{"clone", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", repository, destination)
+
+	if output, err := common.ExecuteCommand(ce, request, nil, common.ClisDir+"/git", args...); err != nil {
+		_, err = common.GetCommandFailureResponse(output, err, false)
+		return errors.Wrap(err, "failed cloning the terraform sources")
+	}
+	return nil
+}
+
+func buildTerraformInitCommand(workspace terraformWorkspace, backendConfig string) string {
+	initArgs := []string{"terraform", "-chdir=" + workspace.WorkingDirectory, "init", "-input=false", "-no-color"}
+	for _, line := range strings.Split(backendConfig, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			initArgs = append(initArgs, "-backend-config="+line)
+		}
+	}
+
+	commands := []string{quoteCommand(initArgs)}
+	if workspace.Name != defaultTerraformWorkspace {
+		selectArgs := []string{"terraform", "-chdir=" + workspace.WorkingDirectory, "workspace", "select", "-no-color", workspace.Name}
+		newArgs := []string{"terraform", "-chdir=" + workspace.WorkingDirectory, "workspace", "new", "-no-color", workspace.Name}
+		commands = append(commands, fmt.Sprintf("{ %s || %s; }", quoteCommand(selectArgs), quoteCommand(newArgs)))
+	}
+
+	return strings.Join(commands, " && ")
+}
+
+// initTerraformCliUser creates the terraform CLI user with either the terraform connection or the aws connection.
+// The returned environment is set even on failure, so the caller can clean the user up.
+func initTerraformCliUser(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) (*execution.PrivateExecutionEnvironment, error) {
+	if credentials, err := ctx.GetCredentials("terraform"); err == nil {
+		token, ok := credentials[terraformToken]
+		if !ok {
+			return nil, errors.New("connection to terraform is invalid")
+		}
+
+		apiServerURL, ok := credentials[terraformAddress]
+		if !ok {
+			return nil, errors.New("connection to terraform is invalid")
+		}
+
+		cliUser, err := e.CreateCliUser("terraform")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create cli user")
+		}
+		ce := e.CreateCliUserPee(cliUser)
+
+		_, err = createTerraFormCredentialsFile(ce, apiServerURL, token)
+		return ce, err
+	}
+
+	region, ok := request.Parameters[regionParameterName]
+	if !ok || region == "" {
+		region = defaultAwsRegion
+	}
+
+	profiles, err := getAwsProfiles(request)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := ctx.GetCredentials("aws")
+	if err != nil {
+		credentials = nil
+	}
+	if credentials, err = resolveAwsConnection(ctx, request, credentials, region, profiles, false, true); err != nil {
+		return nil, errors.Wrap(err, "failed resolving aws credentials")
+	}
+
+	cliUsername, err := initAwsEnv(e, "terraform", credentials, region, profiles)
+	if cliUsername == "" {
+		return nil, err
+	}
+
+	cliUser, lookupErr := user.Lookup(cliUsername)
+	if lookupErr != nil {
+		e.CleanupCliUser(cliUsername)
+		return nil, errors.Wrap(lookupErr, "failed looking up the cli user")
+	}
+	return e.CreateCliUserPee(cliUser), err
+}
+
+// summarizeTerraformPlan counts the planned changes, a replacement counts both as an addition and a destruction.
+func summarizeTerraformPlan(rawPlan []byte) (terraformPlanSummary, error) {
+	summary := terraformPlanSummary{ResourceChanges: []terraformResourceChange{}}
+
+	plan := terraformShowPlan{}
+	if err := json.Unmarshal(rawPlan, &plan); err != nil {
+		return summary, errors.Wrap(err, "failed parsing the terraform plan")
+	}
+
+	for _, resourceChange := range plan.ResourceChanges {
+		actions := resourceChange.Change.Actions
+		for _, action := range actions {
+			switch action {
+			case "create":
+				summary.Summary.Add++
+			case "update":
+				summary.Summary.Change++
+			case "delete":
+				summary.Summary.Destroy++
+			}
+		}
+
+		if len(actions) == 1 && (actions[0] == "no-op" || actions[0] == "read") {
+			continue
+		}
+		summary.ResourceChanges = append(summary.ResourceChanges, terraformResourceChange{Address: resourceChange.Address, Actions: actions})
+	}
+
+	return summary, nil
+}
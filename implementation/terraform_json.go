@@ -0,0 +1,217 @@
+package implementation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// terraformUIMessage is a single line of terraform's machine readable UI, printed when running with -json.
+type terraformUIMessage struct {
+	Level      string                       `json:"@level"`
+	Message    string                       `json:"@message"`
+	Type       string                       `json:"type"`
+	Diagnostic *terraformUIDiagnostic       `json:"diagnostic,omitempty"`
+	Change     *terraformUIChange           `json:"change,omitempty"`
+	Hook       *terraformUIHook             `json:"hook,omitempty"`
+	Changes    *terraformChangeSummary      `json:"changes,omitempty"`
+	Outputs    map[string]terraformUIOutput `json:"outputs,omitempty"`
+}
+
+type terraformUIDiagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail"`
+	Address  string `json:"address"`
+	Range    *struct {
+		Filename string `json:"filename"`
+		Start    struct {
+			Line int `json:"line"`
+		} `json:"start"`
+	} `json:"range,omitempty"`
+}
+
+type terraformUIResource struct {
+	Address string `json:"addr"`
+}
+
+type terraformUIChange struct {
+	Resource terraformUIResource `json:"resource"`
+	Action   string              `json:"action"`
+}
+
+type terraformUIHook struct {
+	Resource terraformUIResource `json:"resource"`
+	Action   string              `json:"action"`
+}
+
+type terraformUIOutput struct {
+	Sensitive bool            `json:"sensitive"`
+	Type      json.RawMessage `json:"type,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+type terraformChangeSummary struct {
+	Add       int    `json:"add"`
+	Change    int    `json:"change"`
+	Remove    int    `json:"remove"`
+	Operation string `json:"operation"`
+}
+
+type terraformDiagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+type terraformChangeEvent struct {
+	Address string `json:"address"`
+	Action  string `json:"action"`
+	Status  string `json:"status"`
+}
+
+type terraformOutput struct {
+	Sensitive bool            `json:"sensitive"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+// terraformJSONResult is the structured result of a terraform command which ran with -json.
+type terraformJSONResult struct {
+	Summary       string                     `json:"summary"`
+	Diagnostics   []terraformDiagnostic      `json:"diagnostics"`
+	Changes       []terraformChangeEvent     `json:"changes"`
+	ChangeSummary *terraformChangeSummary    `json:"change_summary,omitempty"`
+	Outputs       map[string]terraformOutput `json:"outputs,omitempty"`
+}
+
+// the change status of each UI message type which reports on a resource change
+var terraformChangeStatuses = map[string]string{
+	"planned_change": "planned",
+	"resource_drift": "drifted",
+	"apply_complete": "applied",
+	"apply_errored":  "errored",
+}
+
+// parseTerraformJSONOutput parses terraform's -json streaming UI. Lines which aren't UI messages, e.g. output of
+// other commands in the same script, are kept as is in the human summary. The boolean result is false when the
+// output doesn't contain any UI message, i.e. terraform didn't run with -json.
+func parseTerraformJSONOutput(output []byte) (*terraformJSONResult, bool) {
+	result := &terraformJSONResult{
+		Diagnostics: []terraformDiagnostic{},
+		Changes:     []terraformChangeEvent{},
+	}
+
+	var summary []string
+	found := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		message := terraformUIMessage{}
+		if err := json.Unmarshal([]byte(line), &message); err != nil || message.Type == "" {
+			if strings.TrimSpace(line) != "" {
+				summary = append(summary, line)
+			}
+			continue
+		}
+		found = true
+
+		switch message.Type {
+		case "version":
+			continue
+		case "diagnostic":
+			if message.Diagnostic != nil {
+				diagnostic := terraformDiagnostic{
+					Severity: message.Diagnostic.Severity,
+					Summary:  message.Diagnostic.Summary,
+					Detail:   message.Diagnostic.Detail,
+					Address:  message.Diagnostic.Address,
+				}
+				if message.Diagnostic.Range != nil {
+					diagnostic.Filename = message.Diagnostic.Range.Filename
+					diagnostic.Line = message.Diagnostic.Range.Start.Line
+				}
+				result.Diagnostics = append(result.Diagnostics, diagnostic)
+				summary = append(summary, formatTerraformDiagnostic(diagnostic))
+				continue
+			}
+		case "planned_change", "resource_drift":
+			if message.Change != nil {
+				result.Changes = append(result.Changes, terraformChangeEvent{
+					Address: message.Change.Resource.Address,
+					Action:  message.Change.Action,
+					Status:  terraformChangeStatuses[message.Type],
+				})
+			}
+		case "apply_complete", "apply_errored":
+			if message.Hook != nil {
+				result.Changes = append(result.Changes, terraformChangeEvent{
+					Address: message.Hook.Resource.Address,
+					Action:  message.Hook.Action,
+					Status:  terraformChangeStatuses[message.Type],
+				})
+			}
+		case "change_summary":
+			result.ChangeSummary = message.Changes
+		case "outputs":
+			result.Outputs = map[string]terraformOutput{}
+			for name, output := range message.Outputs {
+				result.Outputs[name] = terraformOutput{Sensitive: output.Sensitive, Value: output.Value}
+			}
+		}
+
+		if message.Message != "" {
+			summary = append(summary, message.Message)
+		}
+	}
+
+	if !found {
+		return nil, false
+	}
+
+	result.Summary = strings.Join(summary, "\n")
+	return result, true
+}
+
+func formatTerraformDiagnostic(diagnostic terraformDiagnostic) string {
+	text := fmt.Sprintf("%s: %s", strings.Title(diagnostic.Severity), diagnostic.Summary)
+	if diagnostic.Address != "" {
+		text = fmt.Sprintf("%s (%s)", text, diagnostic.Address)
+	}
+	if diagnostic.Filename != "" {
+		text = fmt.Sprintf("%s\n  on %s line %d", text, diagnostic.Filename, diagnostic.Line)
+	}
+	if diagnostic.Detail != "" {
+		text = fmt.Sprintf("%s\n%s", text, diagnostic.Detail)
+	}
+	return text
+}
+
+// formatTerraformOutput returns the structured result when terraform ran with -json, otherwise the cleaned output.
+func formatTerraformOutput(output []byte) []byte {
+	result, ok := parseTerraformJSONOutput(output)
+	if !ok {
+		return fixTerraFormOutput(output)
+	}
+
+	rawResult, err := json.Marshal(result)
+	if err != nil {
+		return fixTerraFormOutput(output)
+	}
+	return rawResult
+}
+
+// formatTerraformFailure keeps the failure response readable, the diagnostics of a -json run are rendered as text.
+func formatTerraformFailure(output []byte) []byte {
+	if result, ok := parseTerraformJSONOutput(output); ok {
+		return []byte(result.Summary)
+	}
+	return fixTerraFormOutput(output)
+}
@@ -0,0 +1,69 @@
+package implementation
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const terraformApplyJSONOutput = `{"@level":"info","@message":"Terraform 1.0.5","@module":"terraform.ui","terraform":"1.0.5","type":"version","ui":"0.1.0"}
+{"@level":"info","@message":"aws_s3_bucket.logs: Plan to create","@module":"terraform.ui","change":{"resource":{"addr":"aws_s3_bucket.logs","resource_type":"aws_s3_bucket"},"action":"create"},"type":"planned_change"}
+{"@level":"info","@message":"Plan: 1 to add, 0 to change, 0 to destroy.","@module":"terraform.ui","changes":{"add":1,"change":0,"remove":0,"operation":"plan"},"type":"change_summary"}
+{"@level":"info","@message":"aws_s3_bucket.logs: Creation complete after 2s [id=logs]","@module":"terraform.ui","hook":{"resource":{"addr":"aws_s3_bucket.logs"},"action":"create","id_key":"id","id_value":"logs","elapsed_seconds":2},"type":"apply_complete"}
+{"@level":"warn","@message":"Warning: Argument is deprecated","@module":"terraform.ui","diagnostic":{"severity":"warning","summary":"Argument is deprecated","detail":"Use the aws_s3_bucket_acl resource instead","address":"aws_s3_bucket.logs","range":{"filename":"main.tf","start":{"line":3}}},"type":"diagnostic"}
+{"@level":"info","@message":"Apply complete! Resources: 1 added, 0 changed, 0 destroyed.","@module":"terraform.ui","changes":{"add":1,"change":0,"remove":0,"operation":"apply"},"type":"change_summary"}
+{"@level":"info","@message":"Outputs: 2","@module":"terraform.ui","outputs":{"bucket":{"sensitive":false,"type":"string","value":"logs"},"secret":{"sensitive":true,"type":"string"}},"type":"outputs"}
+`
+
+func TestParseTerraformJSONOutput(t *testing.T) {
+	result, ok := parseTerraformJSONOutput([]byte(terraformApplyJSONOutput))
+	require.True(t, ok)
+
+	assert.Equal(t, []terraformDiagnostic{{
+		Severity: "warning",
+		Summary:  "Argument is deprecated",
+		Detail:   "Use the aws_s3_bucket_acl resource instead",
+		Address:  "aws_s3_bucket.logs",
+		Filename: "main.tf",
+		Line:     3,
+	}}, result.Diagnostics)
+
+	assert.Equal(t, []terraformChangeEvent{
+		{Address: "aws_s3_bucket.logs", Action: "create", Status: "planned"},
+		{Address: "aws_s3_bucket.logs", Action: "create", Status: "applied"},
+	}, result.Changes)
+
+	assert.Equal(t, &terraformChangeSummary{Add: 1, Operation: "apply"}, result.ChangeSummary)
+
+	assert.Equal(t, map[string]terraformOutput{
+		"bucket": {Value: json.RawMessage(`"logs"`)},
+		"secret": {Sensitive: true},
+	}, result.Outputs)
+
+	assert.Equal(t, "aws_s3_bucket.logs: Plan to create\n"+
+		"Plan: 1 to add, 0 to change, 0 to destroy.\n"+
+		"aws_s3_bucket.logs: Creation complete after 2s [id=logs]\n"+
+		"Warning: Argument is deprecated (aws_s3_bucket.logs)\n  on main.tf line 3\nUse the aws_s3_bucket_acl resource instead\n"+
+		"Apply complete! Resources: 1 added, 0 changed, 0 destroyed.\n"+
+		"Outputs: 2", result.Summary)
+}
+
+func TestParseTerraformJSONOutputMixed(t *testing.T) {
+	output := "initializing\n" +
+		`{"@level":"error","@message":"Error: Invalid reference","diagnostic":{"severity":"error","summary":"Invalid reference"},"type":"diagnostic"}` + "\n" +
+		"  \n"
+
+	result, ok := parseTerraformJSONOutput([]byte(output))
+	require.True(t, ok)
+
+	assert.Equal(t, []terraformDiagnostic{{Severity: "error", Summary: "Invalid reference"}}, result.Diagnostics)
+	assert.Empty(t, result.Changes)
+	assert.Equal(t, "initializing\nError: Invalid reference", result.Summary)
+}
+
+func TestParseTerraformJSONOutputPlainText(t *testing.T) {
+	_, ok := parseTerraformJSONOutput([]byte("No changes. Your infrastructure matches the configuration.\n{\"not\": \"a message\"}\n"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,380 @@
+package implementation
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blinkops/blink-core/common"
+	"github.com/blinkops/blink-core/implementation/execution"
+	"github.com/blinkops/blink-sdk/plugin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	vaultNamespace           = "VAULT_NAMESPACE"
+	vaultCACert              = "VAULT_CACERT"
+	vaultSkipVerify          = "VAULT_SKIP_VERIFY"
+	vaultAuthMethod          = "VAULT_AUTH_METHOD"
+	vaultAuthMount           = "VAULT_AUTH_MOUNT"
+	vaultRole                = "VAULT_ROLE"
+	vaultRoleID              = "VAULT_ROLE_ID"
+	vaultSecretID            = "VAULT_SECRET_ID"
+	vaultJWT                 = "VAULT_JWT"
+	vaultKubernetesTokenPath = "VAULT_KUBERNETES_TOKEN_PATH"
+	vaultAwsRegion           = "VAULT_AWS_REGION"
+	vaultAwsHeaderValue      = "VAULT_AWS_HEADER_VALUE"
+
+	vaultAuthMethodToken      = "token"
+	vaultAuthMethodAppRole    = "approle"
+	vaultAuthMethodKubernetes = "kubernetes"
+	vaultAuthMethodJWT        = "jwt"
+	vaultAuthMethodOIDC       = "oidc"
+	vaultAuthMethodAws        = "aws"
+
+	defaultKubernetesServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	vaultSecretsParameterName = "vault_secrets"
+)
+
+var (
+	environmentVariableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// kubernetesServiceAccountDirectory holds the kubernetes service account token of the plugin's pod, other
+	// projected tokens, e.g. the web identity token of irsa, are never sent to vault
+	kubernetesServiceAccountDirectory = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// vaultSecretReference is a single line of the vault_secrets parameter: ENV_NAME=path#key
+type vaultSecretReference struct {
+	Name string
+	Path string
+	Key  string
+}
+
+// vaultLogin describes how a token is obtained for an auth method. Secrets are handed to the vault CLI
+// as @file arguments, so they don't show up in the process list.
+type vaultLogin struct {
+	Args    []string
+	Secrets map[string]string
+}
+
+func executeCoreVaultAction(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, request *plugin.ExecuteActionRequest) ([]byte, error) {
+	credentials, err := ctx.GetCredentials("vault")
+	if err != nil {
+		return nil, err
+	}
+
+	command, ok := request.Parameters[commandParameterName]
+	if !ok {
+		return nil, errors.New("command to vault wasn't provided")
+	}
+
+	cliUser, err := e.CreateCliUser("vault")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cli user")
+	}
+	defer e.CleanupCliUser(cliUser.Username)
+	ce := e.CreateCliUserPee(cliUser)
+
+	environment, revoke, err := initVaultEnvironment(ce, ctx, credentials)
+	if revoke != nil {
+		defer revoke()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	environment = append(environment, fmt.Sprintf("VAULT_USER=%s", cliUser.Username))
+
+	// execute the user command
+	output, err := common.ExecuteBash(e, request, environment, command)
+	if err != nil {
+		return common.GetCommandFailureResponse(output, err, true)
+	}
+
+	return output, nil
+}
+
+// initVaultEnvironment logs the CLI user in to vault, the token is stored in the home of the CLI user only.
+// Tokens which were issued by an auth method are revoked by the returned function once the step is done,
+// a static token of the connection is left alone.
+func initVaultEnvironment(ce *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, credentials map[string]string) ([]string, func(), error) {
+	environment, err := buildVaultEnvironment(ce, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	login, err := buildVaultLogin(credentials, ce.GetHomeDirectory())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token := credentials[vaultToken]
+	var revoke func()
+	if login != nil {
+		for name, secret := range login.Secrets {
+			if err = ce.WriteToFile(name, []byte(secret), 0600); err != nil {
+				return nil, nil, errors.Wrap(err, "failed writing vault login secret")
+			}
+		}
+
+		if credentials[vaultAuthMethod] == vaultAuthMethodAws {
+			if err = initVaultAwsCredentials(ce, ctx, credentials); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		output, err := common.ExecuteCommand(ce, nil, environment, common.ClisDir+"/vault", login.Args...)
+		for name := range login.Secrets {
+			_ = os.Remove(name)
+		}
+		if err != nil {
+			_, err = common.GetCommandFailureResponse(output, err, false)
+			return nil, nil, errors.Wrap(err, "failed logging in to vault")
+		}
+
+		token = strings.TrimSpace(string(output))
+		revoke = func() {
+			if output, err := common.ExecuteCommand(ce, nil, environment, common.ClisDir+"/vault", "token", "revoke", "-self"); err != nil {
+				log.Errorf("failed revoking the vault token: %s: %v", string(output), err)
+			}
+		}
+	}
+
+	if token == "" {
+		return nil, revoke, errors.New("connection to vault is invalid")
+	}
+
+	// The vault CLI picks the token up from the token helper file in the home directory of the CLI user.
+	if err = ce.WriteToFile(path.Join(ce.GetHomeDirectory(), ".vault-token"), []byte(token), 0600); err != nil {
+		return nil, revoke, errors.Wrap(err, "failed storing the vault token")
+	}
+
+	return environment, revoke, nil
+}
+
+func buildVaultEnvironment(ce *execution.PrivateExecutionEnvironment, credentials map[string]string) ([]string, error) {
+	apiServerURL, ok := credentials[vaultAddress]
+	if !ok || apiServerURL == "" {
+		return nil, errors.New("connection to vault is invalid")
+	}
+
+	environment := []string{
+		fmt.Sprintf("%s=%s", vaultAddress, apiServerURL),
+		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+	}
+
+	if namespace := credentials[vaultNamespace]; namespace != "" {
+		environment = append(environment, fmt.Sprintf("%s=%s", vaultNamespace, namespace))
+	}
+
+	if caCert := credentials[vaultCACert]; caCert != "" {
+		caCertPath := path.Join(ce.GetHomeDirectory(), "vault-ca.pem")
+		if err := ce.WriteToFile(caCertPath, []byte(caCert), 0600); err != nil {
+			return nil, errors.Wrap(err, "failed writing the vault ca certificate")
+		}
+		environment = append(environment, fmt.Sprintf("%s=%s", vaultCACert, caCertPath))
+	}
+
+	if skipVerify, _ := strconv.ParseBool(credentials[vaultSkipVerify]); skipVerify {
+		environment = append(environment, fmt.Sprintf("%s=true", vaultSkipVerify))
+	}
+
+	return environment, nil
+}
+
+// buildVaultLogin returns the vault CLI arguments which print a token for the auth method of the connection,
+// or nil when the connection holds a static token.
+func buildVaultLogin(credentials map[string]string, secretsDir string) (*vaultLogin, error) {
+	method := credentials[vaultAuthMethod]
+	if method == "" || method == vaultAuthMethodToken {
+		return nil, nil
+	}
+
+	mount := strings.Trim(credentials[vaultAuthMount], "/")
+	if mount == "" {
+		mount = method
+	}
+
+	login := &vaultLogin{Secrets: map[string]string{}}
+	secretArgument := func(key string, name string, value string) string {
+		secretPath := path.Join(secretsDir, name)
+		login.Secrets[secretPath] = value
+		return fmt.Sprintf("%s=@%s", key, secretPath)
+	}
+
+	role := credentials[vaultRole]
+	switch method {
+	case vaultAuthMethodAppRole:
+		if credentials[vaultRoleID] == "" || credentials[vaultSecretID] == "" {
+			return nil, errors.New("vault approle login requires a role id and a secret id")
+		}
+		login.Args = []string{"write", "-field=token", fmt.Sprintf("auth/%s/login", mount),
+			"role_id=" + credentials[vaultRoleID],
+			secretArgument("secret_id", "vault-secret-id", credentials[vaultSecretID]),
+		}
+	case vaultAuthMethodKubernetes:
+		if role == "" {
+			return nil, errors.New("vault kubernetes login requires a role")
+		}
+
+		jwt := credentials[vaultJWT]
+		if jwt == "" {
+			tokenPath := credentials[vaultKubernetesTokenPath]
+			if tokenPath == "" {
+				tokenPath = defaultKubernetesServiceAccountTokenPath
+			}
+
+			var err error
+			if jwt, err = readKubernetesServiceAccountToken(tokenPath); err != nil {
+				return nil, err
+			}
+		}
+
+		login.Args = []string{"write", "-field=token", fmt.Sprintf("auth/%s/login", mount),
+			"role=" + role,
+			secretArgument("jwt", "vault-jwt", jwt),
+		}
+	case vaultAuthMethodJWT, vaultAuthMethodOIDC:
+		if credentials[vaultJWT] == "" {
+			return nil, errors.New("vault jwt login requires a jwt")
+		}
+
+		login.Args = []string{"write", "-field=token", fmt.Sprintf("auth/%s/login", mount)}
+		if role != "" {
+			login.Args = append(login.Args, "role="+role)
+		}
+		login.Args = append(login.Args, secretArgument("jwt", "vault-jwt", credentials[vaultJWT]))
+	case vaultAuthMethodAws:
+		// The request is signed with the credentials of the aws connection, see initVaultAwsCredentials.
+		login.Args = []string{"login", "-no-store", "-token-only", "-method=aws", "-path=" + mount}
+		if role != "" {
+			login.Args = append(login.Args, "role="+role)
+		}
+		if region := credentials[vaultAwsRegion]; region != "" {
+			login.Args = append(login.Args, "region="+region)
+		}
+		if headerValue := credentials[vaultAwsHeaderValue]; headerValue != "" {
+			login.Args = append(login.Args, "header_value="+headerValue)
+		}
+	default:
+		return nil, errors.Errorf("unsupported vault auth method: %s", method)
+	}
+
+	return login, nil
+}
+
+// initVaultAwsCredentials hands the aws connection to the CLI user for the aws auth method. Without an aws
+// connection the vault CLI falls back to the default credential chain, e.g. the instance role.
+func initVaultAwsCredentials(ce *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, credentials map[string]string) error {
+	awsCredentials, err := ctx.GetCredentials("aws")
+	if err != nil {
+		return nil
+	}
+
+	region := credentials[vaultAwsRegion]
+	if region == "" {
+		region = defaultAwsRegion
+	}
+
+	if awsCredentials, err = resolveAwsConnection(ctx, nil, awsCredentials, region, nil, false, false); err != nil {
+		return errors.Wrap(err, "failed resolving aws credentials for vault login")
+	}
+	// the aws login of vault reads the credentials file only, so a credential process is resolved up front
+	if awsCredentials, err = resolveAwsProcessCreds(ce, awsCredentials); err != nil {
+		return errors.Wrap(err, "failed resolving aws credentials for vault login")
+	}
+	return writeAwsFiles(ce, awsCredentials, region, nil, nil)
+}
+
+// resolveVaultSecrets reads the secrets a step declared in vault_secrets with the vault connection of the step.
+func resolveVaultSecrets(e *execution.PrivateExecutionEnvironment, ctx *plugin.ActionContext, references string) (map[string]string, error) {
+	parsedReferences, err := parseVaultSecretReferences(references)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := ctx.GetCredentials("vault")
+	if err != nil {
+		return nil, errors.Wrap(err, "vault secrets require a vault connection")
+	}
+
+	cliUser, err := e.CreateCliUser("vault")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cli user")
+	}
+	defer e.CleanupCliUser(cliUser.Username)
+	ce := e.CreateCliUserPee(cliUser)
+
+	environment, revoke, err := initVaultEnvironment(ce, ctx, credentials)
+	if revoke != nil {
+		defer revoke()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	for _, reference := range parsedReferences {
+		output, err := common.ExecuteCommand(ce, nil, environment, common.ClisDir+"/vault", "kv", "get", "-field="+reference.Key, reference.Path)
+		if err != nil {
+			_, err = common.GetCommandFailureResponse(output, err, false)
+			return nil, errors.Wrapf(err, "failed reading vault secret %s#%s", reference.Path, reference.Key)
+		}
+		secrets[reference.Name] = strings.TrimSuffix(string(output), "\n")
+	}
+
+	return secrets, nil
+}
+
+func parseVaultSecretReferences(references string) ([]vaultSecretReference, error) {
+	var parsed []vaultSecretReference
+	for _, line := range strings.Split(references, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, location, found := cutString(line, "=")
+		secretPath, key, hasKey := cutString(strings.TrimSpace(location), "#")
+		name = strings.TrimSpace(name)
+		if !found || !hasKey || secretPath == "" || key == "" {
+			return nil, errors.Errorf("invalid vault secret reference %q, expected ENV_NAME=path#key", line)
+		}
+		if !environmentVariableNamePattern.MatchString(name) {
+			return nil, errors.Errorf("invalid environment variable name in vault secret reference: %s", name)
+		}
+
+		parsed = append(parsed, vaultSecretReference{Name: name, Path: secretPath, Key: key})
+	}
+	return parsed, nil
+}
+
+// readKubernetesServiceAccountToken reads a service account token of the plugin's pod. The login sends the token to
+// the vault server of the connection, so only the projected secrets of the pod can be read and not any file.
+func readKubernetesServiceAccountToken(tokenPath string) (string, error) {
+	serviceAccountDirectory, err := filepath.EvalSymlinks(kubernetesServiceAccountDirectory)
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading the kubernetes service account token")
+	}
+
+	resolvedPath, err := filepath.EvalSymlinks(tokenPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading the kubernetes service account token")
+	}
+	if !strings.HasPrefix(resolvedPath, serviceAccountDirectory+string(filepath.Separator)) {
+		return "", errors.Errorf("the kubernetes service account token must be under %s", kubernetesServiceAccountDirectory)
+	}
+
+	rawToken, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading the kubernetes service account token")
+	}
+	return strings.TrimSpace(string(rawToken)), nil
+}
@@ -0,0 +1,128 @@
+package implementation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildVaultLogin(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		expected    *vaultLogin
+		wantErr     string
+	}{
+		{
+			name:        "static token",
+			credentials: map[string]string{vaultToken: "s.token"},
+		},
+		{
+			name:        "approle",
+			credentials: map[string]string{vaultAuthMethod: "approle", vaultRoleID: "role-id", vaultSecretID: "secret-id"},
+			expected: &vaultLogin{
+				Args:    []string{"write", "-field=token", "auth/approle/login", "role_id=role-id", "secret_id=@/home/vault/vault-secret-id"},
+				Secrets: map[string]string{"/home/vault/vault-secret-id": "secret-id"},
+			},
+		},
+		{
+			name:        "approle without secret id",
+			credentials: map[string]string{vaultAuthMethod: "approle", vaultRoleID: "role-id"},
+			wantErr:     "vault approle login requires a role id and a secret id",
+		},
+		{
+			name:        "kubernetes with a custom mount",
+			credentials: map[string]string{vaultAuthMethod: "kubernetes", vaultAuthMount: "/k8s-prod/", vaultRole: "blink", vaultJWT: "sa-token"},
+			expected: &vaultLogin{
+				Args:    []string{"write", "-field=token", "auth/k8s-prod/login", "role=blink", "jwt=@/home/vault/vault-jwt"},
+				Secrets: map[string]string{"/home/vault/vault-jwt": "sa-token"},
+			},
+		},
+		{
+			name:        "kubernetes without a role",
+			credentials: map[string]string{vaultAuthMethod: "kubernetes"},
+			wantErr:     "vault kubernetes login requires a role",
+		},
+		{
+			name:        "oidc",
+			credentials: map[string]string{vaultAuthMethod: "oidc", vaultJWT: "id-token"},
+			expected: &vaultLogin{
+				Args:    []string{"write", "-field=token", "auth/oidc/login", "jwt=@/home/vault/vault-jwt"},
+				Secrets: map[string]string{"/home/vault/vault-jwt": "id-token"},
+			},
+		},
+		{
+			name:        "aws",
+			credentials: map[string]string{vaultAuthMethod: "aws", vaultRole: "blink", vaultAwsHeaderValue: "vault.example.com"},
+			expected: &vaultLogin{
+				Args:    []string{"login", "-no-store", "-token-only", "-method=aws", "-path=aws", "role=blink", "header_value=vault.example.com"},
+				Secrets: map[string]string{},
+			},
+		},
+		{
+			name:        "unknown method",
+			credentials: map[string]string{vaultAuthMethod: "userpass"},
+			wantErr:     "unsupported vault auth method: userpass",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, err := buildVaultLogin(tt.credentials, "/home/vault")
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, login)
+		})
+	}
+}
+
+func TestParseVaultSecretReferences(t *testing.T) {
+	references, err := parseVaultSecretReferences("DB_PASSWORD = secret/data/db#password\n\n# the api key\nAPI_KEY=kv/api#key\n")
+	require.NoError(t, err)
+	assert.Equal(t, []vaultSecretReference{
+		{Name: "DB_PASSWORD", Path: "secret/data/db", Key: "password"},
+		{Name: "API_KEY", Path: "kv/api", Key: "key"},
+	}, references)
+
+	for _, invalid := range []string{"DB_PASSWORD=secret/db", "DB_PASSWORD", "1DB=secret/db#password", "DB-PASSWORD=secret/db#password", "DB=#password"} {
+		_, err = parseVaultSecretReferences(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestReadKubernetesServiceAccountToken(t *testing.T) {
+	secretsDirectory := t.TempDir()
+	tokenDirectory := filepath.Join(secretsDirectory, "kubernetes.io", "serviceaccount")
+	defaultServiceAccountDirectory := kubernetesServiceAccountDirectory
+	kubernetesServiceAccountDirectory = tokenDirectory
+	defer func() { kubernetesServiceAccountDirectory = defaultServiceAccountDirectory }()
+
+	require.Nil(t, os.MkdirAll(tokenDirectory, 0700))
+	irsaTokenDirectory := filepath.Join(secretsDirectory, "eks.amazonaws.com", "serviceaccount")
+	require.Nil(t, os.MkdirAll(irsaTokenDirectory, 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(irsaTokenDirectory, "token"), []byte("irsa"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(tokenDirectory, "..token"), []byte("jwt\n"), 0600))
+	require.Nil(t, os.Symlink("..token", filepath.Join(tokenDirectory, "token")))
+
+	outside := filepath.Join(t.TempDir(), "shadow")
+	require.Nil(t, os.WriteFile(outside, []byte("secret"), 0600))
+	require.Nil(t, os.Symlink(outside, filepath.Join(tokenDirectory, "escape")))
+
+	token, err := readKubernetesServiceAccountToken(filepath.Join(tokenDirectory, "token"))
+	require.Nil(t, err)
+	assert.Equal(t, "jwt", token)
+
+	for _, tokenPath := range []string{outside, filepath.Join(irsaTokenDirectory, "token"), filepath.Join(tokenDirectory, "..", "..", "eks.amazonaws.com", "serviceaccount", "token"), filepath.Join(tokenDirectory, "escape"), filepath.Join(tokenDirectory, "..", "..", "..", filepath.Base(filepath.Dir(outside)), "shadow")} {
+		_, err = readKubernetesServiceAccountToken(tokenPath)
+		require.NotNil(t, err, tokenPath)
+		assert.Contains(t, err.Error(), "must be under", tokenPath)
+	}
+}
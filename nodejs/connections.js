@@ -0,0 +1,88 @@
+'use strict';
+
+// Authenticated helpers for the connections of the step, the credentials are resolved by the plugin
+// before the runner starts (e.g. aws roles are already assumed).
+
+function awsConnection(credentials) {
+    return {
+        credentials: credentials,
+        // Usable as the configuration of any AWS SDK (v2 or v3) client, e.g. new S3Client(connections.aws.session())
+        session: function () {
+            const config = {
+                region: credentials.region,
+                credentials: {
+                    accessKeyId: credentials.access_key_id,
+                    secretAccessKey: credentials.secret_access_key,
+                },
+            };
+            if (credentials.session_token) {
+                config.credentials.sessionToken = credentials.session_token;
+            }
+            return config;
+        },
+    };
+}
+
+function kubernetesConnection(credentials) {
+    return {
+        credentials: credentials,
+        client: function (apiType) {
+            const k8s = require('@kubernetes/client-node');
+            const kubeConfig = new k8s.KubeConfig();
+            kubeConfig.loadFromString(credentials.kubeconfig);
+            return kubeConfig.makeApiClient(apiType || k8s.CoreV1Api);
+        },
+    };
+}
+
+function vaultConnection(credentials) {
+    return {
+        credentials: credentials,
+        client: function () {
+            return require('node-vault')({endpoint: credentials.url, token: credentials.token});
+        },
+    };
+}
+
+function smtpConnection(credentials) {
+    return {
+        send: function (to, subject, content) {
+            const transporter = require('nodemailer').createTransport({
+                host: credentials.host,
+                port: parseInt(credentials.port),
+                auth: {user: credentials.username, pass: credentials.password},
+            });
+            return transporter.sendMail({
+                from: credentials.username,
+                to: Array.isArray(to) ? to.join(', ') : to,
+                subject: subject,
+                html: content,
+            });
+        },
+    };
+}
+
+const connectionTypes = {
+    aws: awsConnection,
+    kubernetes: kubernetesConnection,
+    vault: vaultConnection,
+    smtp: smtpConnection,
+};
+
+function createConnections(resolvedConnections) {
+    resolvedConnections = resolvedConnections || {};
+
+    return new Proxy({}, {
+        get: function (target, name) {
+            if (!(name in connectionTypes)) {
+                return undefined;
+            }
+            if (!(name in resolvedConnections)) {
+                throw new Error('no ' + name + ' connection was provided to the action');
+            }
+            return connectionTypes[name](resolvedConnections[name]);
+        },
+    });
+}
+
+module.exports = {createConnections};
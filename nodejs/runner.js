@@ -14,11 +14,13 @@ if (inputArgs[0] === '--input')
     file = inputArgs[1]
 
 const fs = require('fs')
+const {createConnections} = require('./connections')
 
 try {
     const data = fs.readFileSync(file, 'utf8')
     const inputJson = JSON.parse(data)
     const inputCode = inputJson.code
+    const connections = createConnections(inputJson.resolved_connections)
 
     eval(inputCode)
 
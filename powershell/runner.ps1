@@ -0,0 +1,61 @@
+# The PowerShell action runner. The user code runs with $context (a hashtable) and $connections in scope.
+# Objects written to the success stream are the output of the step, while host, information, warning,
+# verbose and debug messages go to the log.
+
+$ErrorActionPreference = 'Stop'
+
+function Write-RunnerOutput([string]$Output, [string]$Log, [string]$ErrorMessage, $Context) {
+    $outputStruct = [ordered]@{
+        output = $Output
+        log    = $Log
+        error  = $ErrorMessage
+    }
+    if ($null -ne $Context) {
+        $outputStruct.context = $Context
+    }
+
+    [Console]::Out.Write(($outputStruct | ConvertTo-Json -Depth 100 -Compress))
+}
+
+if ($args.Count -lt 2 -or $args[0] -ne '--input') {
+    Write-RunnerOutput -Output '' -Log '' -ErrorMessage 'usage: runner.ps1 --input <file>' -Context $null
+    exit 0
+}
+
+$decodedInput = Get-Content -Raw -Path $args[1] | ConvertFrom-Json -AsHashtable
+$context = if ($null -ne $decodedInput.context) { $decodedInput.context } else { @{} }
+$connections = if ($null -ne $decodedInput.resolved_connections) { $decodedInput.resolved_connections } else { @{} }
+
+$outputItems = [System.Collections.Generic.List[object]]::new()
+$logLines = [System.Collections.Generic.List[string]]::new()
+
+try {
+    $code = [ScriptBlock]::Create($decodedInput.code)
+    . $code *>&1 | ForEach-Object {
+        if ($_ -is [System.Management.Automation.InformationRecord] -or
+            $_ -is [System.Management.Automation.WarningRecord] -or
+            $_ -is [System.Management.Automation.VerboseRecord] -or
+            $_ -is [System.Management.Automation.DebugRecord] -or
+            $_ -is [System.Management.Automation.ErrorRecord]) {
+            $logLines.Add($_.ToString())
+        } else {
+            $outputItems.Add($_)
+        }
+    }
+} catch {
+    $message = "User provided code raised an exception: `n`r$($_.Exception.Message)`n`r$($_.Exception.GetType().FullName)`n`rLine: $($_.InvocationInfo.ScriptLineNumber)"
+    Write-RunnerOutput -Output '' -Log ($logLines -join "`n") -ErrorMessage $message -Context $null
+    exit 0
+}
+
+# A single string is returned as is, anything else is returned as json so the workflow can consume it.
+$output = ''
+if ($outputItems.Count -eq 1 -and $outputItems[0] -is [string]) {
+    $output = $outputItems[0]
+} elseif ($outputItems.Count -eq 1) {
+    $output = ConvertTo-Json -InputObject $outputItems[0] -Depth 100 -Compress
+} elseif ($outputItems.Count -gt 1) {
+    $output = ConvertTo-Json -InputObject $outputItems.ToArray() -Depth 100 -Compress
+}
+
+Write-RunnerOutput -Output $output -Log ($logLines -join "`n") -ErrorMessage '' -Context $context
@@ -0,0 +1,95 @@
+import smtplib
+
+from email.message import EmailMessage
+
+from exception import CredentialsException
+
+
+class AwsConnection:
+
+    def __init__(self, credentials: dict):
+        self.credentials = credentials
+
+    def session(self):
+        import boto3
+
+        return boto3.session.Session(
+            aws_access_key_id=str(self.credentials.get('access_key_id')),
+            aws_secret_access_key=str(self.credentials.get('secret_access_key')),
+            aws_session_token=str(self.credentials['session_token']) if self.credentials.get('session_token') else None,
+            region_name=str(self.credentials.get('region')),
+        )
+
+    def client(self, service_name: str):
+        return self.session().client(service_name)
+
+
+class KubernetesConnection:
+
+    def __init__(self, credentials: dict):
+        self.credentials = credentials
+
+    def client(self):
+        import yaml
+        from kubernetes import config
+
+        return config.new_client_from_config_dict(yaml.safe_load(self.credentials.get('kubeconfig')))
+
+
+class VaultConnection:
+
+    def __init__(self, credentials: dict):
+        self.credentials = credentials
+
+    def client(self):
+        import hvac
+
+        return hvac.Client(url=str(self.credentials.get('url')), token=str(self.credentials.get('token')))
+
+
+class SmtpConnection:
+
+    def __init__(self, credentials: dict):
+        self.credentials = credentials
+
+    def send(self, to, subject: str, content: str, subtype: str = 'html'):
+        message = EmailMessage()
+        message['From'] = str(self.credentials.get('username'))
+        message['To'] = to if isinstance(to, str) else ', '.join(to)
+        message['Subject'] = subject
+        message.set_content(content, subtype=subtype)
+
+        with smtplib.SMTP(str(self.credentials.get('host')), int(self.credentials.get('port'))) as server:
+            server.starttls()
+            server.login(str(self.credentials.get('username')), str(self.credentials.get('password')))
+            server.send_message(message)
+
+
+class Connections:
+    """
+    Authenticated helpers for the connections of the step, the credentials are resolved by the plugin
+    before the runner starts (e.g. aws roles are already assumed).
+    """
+
+    types = {
+        'aws': AwsConnection,
+        'kubernetes': KubernetesConnection,
+        'vault': VaultConnection,
+        'smtp': SmtpConnection,
+    }
+
+    def __init__(self, resolved_connections: dict):
+        object.__setattr__(self, 'resolved_connections', resolved_connections or {})
+
+    def __getattr__(self, item):
+        if item not in self.types:
+            raise AttributeError(f'unknown connection type: {item}')
+
+        credentials = self.resolved_connections.get(item)
+        if credentials is None:
+            raise CredentialsException(f'no {item} connection was provided to the action')
+
+        return self.types[item](credentials)
+
+    def __str__(self):
+        return str(list(self.resolved_connections.keys()))
@@ -1,5 +1,6 @@
 import argparse
 import json
+import logging
 import sys
 import traceback
 import runpy
@@ -9,13 +10,15 @@ from io import StringIO
 from traceback import StackSummary
 from typing import Dict
 
+from connections import Connections
 from context import Context
 from exception import CodeExecutionError
 
 
-def write_output(output: str, context: Context, error):
+def write_output(output: str, context: Context, error, log: str = ""):
     output_struct = {
         "output": output,
+        "log": log,
         "error": str(error),
     }
 
@@ -44,7 +47,19 @@ def decode_raw_input(raw_input_file) -> dict:
 
 
 
-def execute_user_supplied_code(context: Context, code_to_be_executed: str):
+class DeclaredOutput:
+
+    def __init__(self):
+        self.is_set = False
+        self.value = ""
+
+    def set(self, value):
+        # Anything that isn't already a string is returned as json so the workflow can consume it.
+        self.value = value if isinstance(value, str) else json.dumps(value)
+        self.is_set = True
+
+
+def execute_user_supplied_code(context: Context, connections: Connections, code_to_be_executed: str, set_output):
     with tempfile.NamedTemporaryFile(mode='w') as f:
         f.write(code_to_be_executed)
         f.flush()
@@ -52,17 +67,24 @@ def execute_user_supplied_code(context: Context, code_to_be_executed: str):
         runpy.run_path(f.name, init_globals=locals())
 
 
-def entry_point(raw_input_file):
+def entry_point(raw_input_file, log_buffer: StringIO):
     decoded_input = decode_raw_input(raw_input_file)
 
     context = Context(decoded_input['context'])
+    connections = Connections(decoded_input.get('resolved_connections'))
     code_to_be_executed = decoded_input['code']
+    declared_output = DeclaredOutput()
+
+    # Prints and logging records of the user code go to the log channel, the result is declared with set_output.
+    log_handler = logging.StreamHandler(log_buffer)
+    logging.getLogger().addHandler(log_handler)
+    logging.getLogger().setLevel(logging.INFO)
 
     try:
-        output_buffer = StringIO()
-        sys.stdout = output_buffer
+        sys.stdout = log_buffer
 
-        execute_user_supplied_code(context=context, code_to_be_executed=code_to_be_executed)
+        execute_user_supplied_code(context=context, connections=connections,
+                                   code_to_be_executed=code_to_be_executed, set_output=declared_output.set)
     except Exception as e:
         # Note: All 'by value' list accesses are safe due to the python spec.
         error_line = StackSummary.extract(traceback.walk_tb(sys.exc_info()[2]))[-1].lineno
@@ -70,8 +92,13 @@ def entry_point(raw_input_file):
             f'User provider code raised an exception: \n\r{str(e)}\n\r{type(e)}\n\rLine: {error_line}')
     finally:
         sys.stdout = sys.__stdout__
+        logging.getLogger().removeHandler(log_handler)
+
+    # Code which doesn't declare a result keeps returning whatever it printed.
+    if not declared_output.is_set:
+        return log_buffer.getvalue(), context
 
-    return output_buffer, context
+    return declared_output.value, context
 
 
 def main():
@@ -81,17 +108,18 @@ def main():
     )
 
     arguments = parser.parse_args()
+    log_buffer = StringIO()
 
     try:
-        output_buffer, context = entry_point(arguments.input)
+        output, context = entry_point(arguments.input, log_buffer)
     except CodeExecutionError as e:
-        write_output(output="", error=e, context=None)
+        write_output(output="", error=e, context=None, log=log_buffer.getvalue())
         return
     except:
-        write_output(output="", error=traceback.format_exc(), context=None)
+        write_output(output="", error=traceback.format_exc(), context=None, log=log_buffer.getvalue())
         return
 
-    write_output(output=output_buffer.getvalue(), error="", context=context)
+    write_output(output=output, error="", context=context, log=log_buffer.getvalue())
 
 
 if __name__ == '__main__':
@@ -0,0 +1,64 @@
+# The ruby action runner. The user code is evaluated with `context` and `connections` in scope,
+# whatever it prints goes to the log and the result of the step is declared with set_output(value).
+
+require 'json'
+require 'optparse'
+require 'stringio'
+
+class CodeScope
+  attr_reader :context, :connections, :declared_output
+
+  def initialize(context, connections)
+    @context = context
+    @connections = connections
+    @declared_output = nil
+  end
+
+  def set_output(value)
+    # Anything that isn't already a string is returned as json so the workflow can consume it.
+    @declared_output = value.is_a?(String) ? value : JSON.generate(value)
+  end
+
+  def run(code)
+    instance_eval(code, 'code.rb')
+  end
+end
+
+def write_output(output, log, error, context)
+  output_struct = {
+    'output' => output,
+    'log' => log,
+    'error' => error.to_s
+  }
+  output_struct['context'] = context unless context.nil?
+
+  STDOUT.write(JSON.generate(output_struct))
+end
+
+def main
+  input_file = nil
+  OptionParser.new do |parser|
+    parser.on('--input FILE', 'File location containing the raw marshaled input json struct') { |file| input_file = file }
+  end.parse!
+
+  decoded_input = JSON.parse(File.read(input_file))
+  scope = CodeScope.new(decoded_input['context'] || {}, decoded_input['resolved_connections'] || {})
+  log_buffer = StringIO.new
+
+  begin
+    $stdout = log_buffer
+    scope.run(decoded_input['code'])
+  rescue Exception => e
+    line = e.backtrace_locations&.find { |location| location.path == 'code.rb' }&.lineno
+    write_output('', log_buffer.string, "User provided code raised an exception: \n\r#{e.message}\n\r#{e.class}\n\rLine: #{line}", nil)
+    return
+  ensure
+    $stdout = STDOUT
+  end
+
+  # Code which doesn't declare a result keeps returning whatever it printed.
+  output = scope.declared_output.nil? ? log_buffer.string : scope.declared_output
+  write_output(output, log_buffer.string, '', scope.context)
+end
+
+main